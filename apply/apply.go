@@ -1,14 +1,19 @@
 package apply
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/Clever/mongo-op-throttler/convert"
+	"github.com/Clever/mongo-op-throttler/deadletter"
+	"github.com/Clever/mongo-op-throttler/metrics"
 	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/target"
+	"github.com/Clever/mongo-op-throttler/transform"
 	// Use custom scanner with higher length limitation
 	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
 
@@ -16,48 +21,266 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
+// Filter decides whether an operation should be applied. It returns false to skip it.
+type Filter func(op operation.Op) bool
+
+// Options configures a replay
+type Options struct {
+	// Input is the oplog to replay, as BSON oplog entries one after another like mongodump writes them.
+	// Entries are only split by size; read a raw dump through an input.Merger to check them first.
+	Input io.Reader
+	// Decode converts each entry of Input to an operation, or nil if it's a no-op. Defaults to
+	// convert.OplogBytesToOp. Set it to operation.Unmarshal to replay an ops file instead.
+	Decode func(raw []byte) (*operation.Op, error)
+	// Target is where operations are applied. If it's nil they're applied to Session.
+	Target target.Target
+	// Session is the Mongo session operations are applied with when Target isn't set
+	Session *mgo.Session
+	// OpsPerSecond is the maximum number of operations applied per second. Zero or less means
+	// there's no limit. It's ignored if Limiter is set.
+	OpsPerSecond float64
+	// Limiter, if set, decides when each operation can be applied instead of OpsPerSecond
+	Limiter Limiter
+	// Transformer, if set, is run on every operation before it's applied. It can modify
+	// the operation, drop it, or fan it out into several operations.
+	Transformer transform.Transformer
+	// Filters are run on every operation after the Transformer. An operation is only applied
+	// if every filter returns true.
+	Filters []Filter
+	// OnError decides what happens when an entry fails to convert, transform or apply. By
+	// default the replay stops with the error.
+	OnError ErrorHandler
+	// Retry controls how operations that fail with transient errors, like those during a
+	// primary election, are retried. The zero value doesn't retry.
+	Retry RetryPolicy
+	// TotalBytes is the size of the input, if known. It's used to compute the percent complete
+	// and estimate the time remaining.
+	TotalBytes int64
+	// ProgressInterval is how often to report progress. Defaults to DefaultProgressInterval.
+	ProgressInterval time.Duration
+	// OnProgress, if set, is called with the progress every ProgressInterval instead of it being logged
+	OnProgress func(Progress)
+	// Checkpoint, if set, is called with each raw entry once it's been fully processed,
+	// whether it was applied, skipped or handled by OnError, so a later replay can start after
+	// it. The entry is only valid until it returns. The replay stops if it returns an error.
+	Checkpoint func(entry []byte) error
+	// Description, if set, is logged with the start and summary of the replay. It's used to
+	// record settings like the write concern alongside the results.
+	Description string
+}
+
+// Result summarizes a replay that finished or stopped early
+type Result struct {
+	Progress
+	// ResumeOffset is where in the input a later replay should start to pick up where this one
+	// left off. It's the offset of the first entry that wasn't fully processed.
+	ResumeOffset int64
+}
+
+// ErrInterrupted is returned by Run when its context is done before the input is finished
+var ErrInterrupted = errors.New("Replay interrupted")
+
+// ErrNoTarget is returned by Run when neither Options.Target nor Options.Session is set
+var ErrNoTarget = errors.New("No target to apply operations to, set Options.Target or Options.Session")
+
 // applyOps applies all the operations in the io.Reader to the specified
 // database session at the specified speed.
 // Note that applyOps is idempotent so it can be run repeatedly. It does
 // this by doing things like converting inserts into upserts. For more details
 // so the applyOp code.
 func ApplyOps(r io.Reader, opsPerSecond float64, session *mgo.Session) error {
-	log.Printf("Beginning to replay")
-	opScanner := bsonScanner.New(r)
+	_, err := Run(context.Background(), Options{
+		Input:        r,
+		Session:      session,
+		OpsPerSecond: opsPerSecond,
+	})
+	return err
+}
+
+// Run replays the oplog in opts.Input. It's the same as ApplyOps, but with more control over
+// the replay. When ctx is done Run lets the operation being applied finish, or abandons it if
+// the target is a target.Contexter, and returns ErrInterrupted. It needs opts.Target or opts.Session to apply to. It always returns a Result,
+// even along with an error.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Target == nil && opts.Session == nil {
+		return &Result{}, ErrNoTarget
+	}
+	if opts.Description != "" {
+		log.Printf("Beginning to replay with %s", opts.Description)
+	} else {
+		log.Printf("Beginning to replay")
+	}
+	opScanner := bsonScanner.NewUnchecked(opts.Input)
+	decode := decoder(opts)
+
+	tgt := opts.Target
+	if tgt == nil && opts.Session != nil {
+		tgt = target.NewMongo(opts.Session)
+	}
+	if c, ok := tgt.(target.Contexter); ok {
+		tgt = c.WithContext(ctx)
+	}
+	limiter := opts.Limiter
+	if limiter == nil {
+		limiter = NewRateLimiter(opts.OpsPerSecond)
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = stopOnError
+	}
+	progress := newProgressTracker(opts.TotalBytes, opts.ProgressInterval, opts.OnProgress)
+	progress.description = opts.Description
+	// A custom limiter's rate isn't known, so the metric is left alone
+	if r, ok := limiter.(rated); ok {
+		metrics.TargetRate.Set(r.Rate())
+	}
 
-	start := time.Now()
-	numOps := 0
+	result := &Result{}
+	finish := func(err error) (*Result, error) {
+		result.Progress = progress.finish()
+		if err == ErrInterrupted {
+			log.Printf("Interrupted before finishing the oplog entry at offset %d. The last applied op had oplog ts %d",
+				result.ResumeOffset, result.LastTimestamp)
+		}
+		return result, err
+	}
+	// fail records an entry that failed at some stage and asks the error handler what to do
+	fail := func(stage, namespace string, err error) error {
+		progress.current.Failed++
+		if namespace == "" {
+			namespace = entryNamespace(opScanner.Bytes())
+		}
+		return onError(EntryError{
+			Offset:    opScanner.Offset(),
+			Stage:     stage,
+			Namespace: namespace,
+			Raw:       opScanner.Bytes(),
+			Err:       err,
+		})
+	}
+
+	// checkpoint records that the entry before the current one is done. It's copied since the
+	// scanner reuses its buffer.
+	var previous []byte
+	checkpoint := func() error {
+		if opts.Checkpoint == nil || previous == nil {
+			return nil
+		}
+		if err := opts.Checkpoint(previous); err != nil {
+			return fmt.Errorf("Error saving checkpoint %s", err)
+		}
+		return nil
+	}
 
 	for opScanner.Scan() {
+		result.ResumeOffset = opScanner.Offset()
+		if err := checkpoint(); err != nil {
+			return finish(err)
+		}
+		if ctx.Err() != nil {
+			return finish(ErrInterrupted)
+		}
+		if opts.Checkpoint != nil {
+			previous = append(previous[:0], opScanner.Bytes()...)
+		}
+		bytesRead := opScanner.Offset() + int64(len(opScanner.Bytes()))
+		metrics.BytesRead.Add(float64(bytesRead - progress.current.Bytes))
+		progress.current.Bytes = bytesRead
+		progress.current.Entries++
 
-		op, err := convert.OplogBytesToOp(opScanner.Bytes())
+		op, err := decode(opScanner.Bytes())
 		if err != nil {
-			return fmt.Errorf("Error interpreting oplog entry %s", err.Error())
+			metrics.OpsFailed.WithLabelValues(metrics.Unknown, metrics.Unknown).Inc()
+			if err := fail(deadletter.StageConvert, "", fmt.Errorf("Error interpreting oplog entry %s", err.Error())); err != nil {
+				return finish(err)
+			}
+			continue
 		}
 		// It is possible for an op to be a no-op, but not an error. For example an index creation
 		if op == nil {
+			metrics.OpsSkipped.WithLabelValues(metrics.Unknown, "noop").Inc()
+			progress.current.NoOps++
 			continue
 		}
 
-		millisElapsed := time.Now().Sub(start).Nanoseconds() / (1000 * 1000)
-		expectedMillisElapsed := (float64(numOps) / opsPerSecond) * 1000
-
-		timeToWait := int64(expectedMillisElapsed) - millisElapsed
-		if timeToWait > 0 {
-			time.Sleep(time.Duration(timeToWait) * time.Millisecond)
+		ops := []operation.Op{*op}
+		if opts.Transformer != nil {
+			if ops, err = opts.Transformer.Transform(*op); err != nil {
+				metrics.OpsFailed.WithLabelValues(op.Namespace, op.Type).Inc()
+				if err := fail(deadletter.StageTransform, op.Namespace, fmt.Errorf("Error transforming op %s", err.Error())); err != nil {
+					return finish(err)
+				}
+				continue
+			}
+			if len(ops) == 0 {
+				metrics.OpsSkipped.WithLabelValues(op.Namespace, op.Type).Inc()
+				progress.current.Filtered++
+			}
 		}
 
-		if err := applyOp(*op, session); err != nil {
-			return err
-		}
-		numOps++
+		for _, op := range ops {
+			if !passesFilters(op, opts.Filters) {
+				metrics.OpsSkipped.WithLabelValues(op.Namespace, op.Type).Inc()
+				progress.current.Filtered++
+				continue
+			}
 
-		if numOps%1000 == 0 {
-			log.Printf("Processed %d ops", numOps)
+			// Failed ops count against the rate limit too, since they still hit the database.
+			// Re-running part of an entry after being interrupted is fine since applying ops is idempotent.
+			if err := limiter.Wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					return finish(ErrInterrupted)
+				}
+				return finish(err)
+			}
+
+			retries, err := applyOpWithRetries(ctx, op, tgt, opts.Retry)
+			progress.current.Retries += retries
+			if err != nil && ctx.Err() != nil {
+				// It was stopped while waiting to retry, so the entry is replayed again on resume
+				return finish(ErrInterrupted)
+			}
+			if err != nil {
+				if err := fail(deadletter.StageApply, op.Namespace, err); err != nil {
+					return finish(err)
+				}
+				continue
+			}
+			if op.Timestamp != 0 {
+				progress.current.LastTimestamp = op.Timestamp
+				metrics.LastAppliedTimestamp.Set(float64(int64(op.Timestamp) >> 32))
+			}
+			progress.current.Applied++
+			progress.maybeReport()
 		}
 	}
 
-	return opScanner.Err()
+	result.ResumeOffset = progress.current.Bytes
+	if err := checkpoint(); err != nil {
+		return finish(err)
+	}
+	// Inputs that never end, like a tailed oplog, stop with an error when the context is done
+	if ctx.Err() != nil {
+		return finish(ErrInterrupted)
+	}
+	return finish(opScanner.Err())
+}
+
+// decoder returns the function that converts input entries to operations
+func decoder(opts Options) func(raw []byte) (*operation.Op, error) {
+	if opts.Decode != nil {
+		return opts.Decode
+	}
+	return convert.OplogBytesToOp
+}
+
+func passesFilters(op operation.Op, filters []Filter) bool {
+	for _, filter := range filters {
+		if !filter(op) {
+			return false
+		}
+	}
+	return true
 }
 
 // applyOp applies a single operation to a database. Note that we apply a single
@@ -68,42 +291,34 @@ func ApplyOps(r io.Reader, opsPerSecond float64, session *mgo.Session) error {
 // Given these limitations, it seemed like just applying them serially was meaningfully
 // simpler, and in testing we could get close to 1K ops per second applying them serially,
 // so we decided that was good enough for now and we could revisit later if we needed more speed.
-func applyOp(op operation.Op, session *mgo.Session) error {
-	splitNamespace := strings.SplitN(op.Namespace, ".", 2)
-	if len(splitNamespace) != 2 {
-		return fmt.Errorf("Invalid namespace: %s", op.Namespace)
-	}
-
-	if !bson.IsObjectIdHex(op.ID) {
-		return fmt.Errorf("Invalid ID: %s", op.ID)
+func applyOp(op operation.Op, t target.Target) error {
+	if err := ValidateOp(op); err != nil {
+		return err
 	}
 	id := bson.ObjectIdHex(op.ID)
 
-	c := session.DB(splitNamespace[0]).C(splitNamespace[1])
-
 	switch op.Type {
 	case "insert":
-		_, err := c.UpsertId(id, op.Obj)
-		return err
+		return t.Upsert(op.Namespace, id, op.Obj)
 
 	case "update":
-		err := c.UpdateId(id, op.Obj)
+		err := t.Update(op.Namespace, id, op.Obj)
 		// Don't error on mgo not found because we want to support idempotency
 		// and the document could have been removed in a previous run
 		// See https://github.com/mongodb/docs/commit/238d6755a74c3c978cc272d318283f726379a43c
 		// for more details. Ideally we would turn this into a upsert, but we can't do that
 		// until we get Mongo 2.6 oplogs (2.4 ones don't have enough of the document to do an
 		// upsert)
-		if err == mgo.ErrNotFound {
+		if err == target.ErrNotFound {
 			return nil
 		}
 		return err
 
 	case "remove":
-		err := c.RemoveId(id)
+		err := t.Remove(op.Namespace, id)
 		// Don't error on mgo not found because we want to support idempotency
 		// and the document could have been removed in a previous run
-		if err == mgo.ErrNotFound {
+		if err == target.ErrNotFound {
 			return nil
 		}
 		return err
@@ -112,3 +327,41 @@ func applyOp(op operation.Op, session *mgo.Session) error {
 		return fmt.Errorf("Unknown type: %s", op.Type)
 	}
 }
+
+// entryNamespace makes a best effort attempt to read the namespace of a raw oplog entry
+func entryNamespace(raw []byte) string {
+	var entry struct {
+		Namespace string `bson:"ns"`
+	}
+	bson.Unmarshal(raw, &entry)
+	return entry.Namespace
+}
+
+// observeApply records the outcome of applying an op in the metrics
+func observeApply(op operation.Op, start time.Time, err error) {
+	if err != nil {
+		metrics.OpsFailed.WithLabelValues(op.Namespace, op.Type).Inc()
+		return
+	}
+	metrics.ApplyLatency.WithLabelValues(op.Namespace, op.Type).Observe(time.Now().Sub(start).Seconds())
+	metrics.OpsApplied.WithLabelValues(op.Namespace, op.Type).Inc()
+}
+
+// ValidateOp checks that an op is something applyOp knows how to apply, without
+// touching the database
+func ValidateOp(op operation.Op) error {
+	if _, _, err := target.SplitNamespace(op.Namespace); err != nil {
+		return err
+	}
+
+	if !bson.IsObjectIdHex(op.ID) {
+		return fmt.Errorf("Invalid ID: %s", op.ID)
+	}
+
+	switch op.Type {
+	case "insert", "update", "remove":
+		return nil
+	default:
+		return fmt.Errorf("Unknown type: %s", op.Type)
+	}
+}
@@ -2,11 +2,18 @@ package apply
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/deadletter"
+	"github.com/Clever/mongo-op-throttler/metrics"
 	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/target"
+	"github.com/Clever/mongo-op-throttler/transform"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -152,6 +159,20 @@ func TestInvalidObjectId(t *testing.T) {
 	assert.Equal(t, "Invalid ID: bad", err.Error())
 }
 
+func TestFailedOpMetrics(t *testing.T) {
+	failed := metrics.OpsFailed.WithLabelValues("bad", "remove")
+	before := testutil.ToFloat64(failed)
+
+	op := operation.Op{
+		ID:        bson.NewObjectId().Hex(),
+		Type:      "remove",
+		Namespace: "bad",
+	}
+	_, err := applyOpWithRetries(context.Background(), op, nil, DefaultRetryPolicy)
+	assert.Error(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(failed))
+}
+
 func TestInvalidType(t *testing.T) {
 	op := operation.Op{
 		ID:        bson.NewObjectId().Hex(),
@@ -178,7 +199,7 @@ func TestUpdate(t *testing.T) {
 		Namespace: "throttle.test",
 		Obj:       updatedObj,
 	}
-	assert.NoError(t, applyOp(op, db.Session))
+	assert.NoError(t, applyOp(op, target.NewMongo(db.Session)))
 
 	var result bson.M
 	assert.NoError(t, db.C("test").Find(bson.M{}).One(&result))
@@ -187,13 +208,13 @@ func TestUpdate(t *testing.T) {
 	// Try with the $set syntax
 	op.Obj = bson.M{"$set": bson.M{"key": "value3"}}
 
-	assert.NoError(t, applyOp(op, db.Session))
+	assert.NoError(t, applyOp(op, target.NewMongo(db.Session)))
 	assert.NoError(t, db.C("test").Find(bson.M{}).One(&result))
 	assert.Equal(t, "value3", result["key"].(string))
 
 	// Updating a doc that doesn't exist doesn't fail
 	op.ID = bson.NewObjectId().Hex()
-	assert.NoError(t, applyOp(op, db.Session))
+	assert.NoError(t, applyOp(op, target.NewMongo(db.Session)))
 }
 
 func TestInsert(t *testing.T) {
@@ -208,7 +229,7 @@ func TestInsert(t *testing.T) {
 		Namespace: "throttle.test",
 		Obj:       obj,
 	}
-	assert.NoError(t, applyOp(op, db.Session))
+	assert.NoError(t, applyOp(op, target.NewMongo(db.Session)))
 
 	var result bson.M
 	assert.NoError(t, db.C("test").Find(bson.M{"_id": id}).One(&result))
@@ -226,9 +247,223 @@ func TestRemove(t *testing.T) {
 		Type:      "remove",
 		Namespace: "throttle.test",
 	}
-	assert.NoError(t, applyOp(op, db.Session))
+	assert.NoError(t, applyOp(op, target.NewMongo(db.Session)))
 
 	count, err := db.C("test").Count()
 	assert.NoError(t, err)
 	assert.Equal(t, 0, count)
 }
+
+func TestApplyOpsWithTransformer(t *testing.T) {
+	db := setupDb(t)
+
+	buffer := bytes.NewBufferString("")
+	for i := 0; i < 3; i++ {
+		buffer.Write(createInsert(t))
+	}
+
+	// Drop every other op and fan the rest out to a second collection
+	numSeen := 0
+	transformer := transform.Func(func(op operation.Op) ([]operation.Op, error) {
+		numSeen++
+		if numSeen%2 == 0 {
+			return nil, nil
+		}
+		dup := op
+		dup.Namespace = "throttle.copy"
+		return []operation.Op{op, dup}, nil
+	})
+	result, err := Run(context.Background(), Options{Input: buffer, Session: db.Session, OpsPerSecond: 1000, Transformer: transformer})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Applied)
+	assert.Equal(t, 1, result.Filtered)
+
+	count, err := db.C("test").Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = db.C("copy").Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestContinueOnError(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	command, err := bson.Marshal(bson.M{"v": 2, "op": "c", "ns": "throttle.$cmd", "o": bson.M{"create": "test"}})
+	assert.NoError(t, err)
+	buffer.Write(command)
+	stringIDOffset := buffer.Len()
+	stringID, err := bson.Marshal(bson.M{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": "stringId"}})
+	assert.NoError(t, err)
+	buffer.Write(stringID)
+	input := buffer.Bytes()
+
+	// Without ContinueOnError the first failure stops the replay
+	_, err = Run(context.Background(), Options{Input: bytes.NewReader(input), Target: target.NewMemory()})
+	assert.Error(t, err)
+	assert.Equal(t, "Error interpreting oplog entry Unknown op type c", err.Error())
+
+	// With it, both failures are written to the dead letter file. Neither touches the target
+	// since they fail before reaching it.
+	deadLetters := bytes.NewBufferString("")
+	opts := Options{
+		Input:   bytes.NewReader(input),
+		Target:  target.NewMemory(),
+		OnError: ContinueOnError(deadletter.NewWriter(deadLetters, deadletter.BSON), 0),
+	}
+	result, err := Run(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Failed)
+	assert.Equal(t, int64(len(input)), result.ResumeOffset)
+
+	var entries []bson.M
+	scanner := bsonScanner.New(deadLetters)
+	for scanner.Scan() {
+		var entry bson.M
+		assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, int64(0), entries[0]["offset"])
+	assert.Equal(t, "throttle.$cmd", entries[0]["ns"])
+	assert.Equal(t, deadletter.StageConvert, entries[0]["stage"])
+	assert.Equal(t, int64(stringIDOffset), entries[1]["offset"])
+	assert.Equal(t, "throttle.test", entries[1]["ns"])
+	assert.Equal(t, deadletter.StageApply, entries[1]["stage"])
+	assert.Equal(t, "Invalid ID: stringId", entries[1]["error"])
+
+	// MaxErrors stops the replay once it's hit
+	opts = Options{Input: bytes.NewReader(input), Target: target.NewMemory(), OnError: ContinueOnError(nil, 2)}
+	_, err = Run(context.Background(), opts)
+	assert.Error(t, err)
+	assert.Equal(t, "Stopping after 2 failures, the last was Invalid ID: stringId", err.Error())
+}
+
+func TestRunInterrupted(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	buffer.Write(createInsert(t))
+	input := buffer.Bytes()
+
+	// Canceling before starting doesn't touch the database
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := Run(ctx, Options{Input: bytes.NewReader(input), Target: target.NewMemory()})
+	assert.Equal(t, ErrInterrupted, err)
+	assert.Equal(t, int64(0), result.ResumeOffset)
+
+	// Stopping interrupts waiting for the rate limit. The transformer fans the entry out to
+	// ops that fail validation so that they don't need a session either.
+	fanOut := transform.Func(func(op operation.Op) ([]operation.Op, error) {
+		op.ID = "bad"
+		return []operation.Op{op, op, op}, nil
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	opts := Options{Input: bytes.NewReader(input), Target: target.NewMemory(), OpsPerSecond: 0.01, Transformer: fanOut, OnError: ContinueOnError(nil, 0)}
+	_, err = Run(ctx, opts)
+	assert.Equal(t, ErrInterrupted, err)
+	assert.True(t, time.Now().Sub(start) < time.Second)
+}
+
+func TestRunWithoutTarget(t *testing.T) {
+	result, err := Run(context.Background(), Options{Input: bytes.NewReader(createInsert(t))})
+	assert.Equal(t, ErrNoTarget, err)
+	assert.NotNil(t, result)
+}
+
+func TestRunWithMemoryTarget(t *testing.T) {
+	memory := target.NewMemory()
+	toUpdateID := bson.NewObjectId()
+	toRemoveID := bson.NewObjectId()
+	assert.NoError(t, memory.Upsert("throttle.test", toUpdateID, bson.M{"key": "update", "other": "value"}))
+	assert.NoError(t, memory.Upsert("throttle.test", toRemoveID, bson.M{"key": "remove"}))
+
+	buffer := bytes.NewBufferString("")
+	toInsertID := bson.NewObjectId()
+	entries := []bson.M{
+		{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": toInsertID, "key": "insert"}},
+		{"v": 2, "op": "u", "ns": "throttle.test", "o": bson.M{"$set": bson.M{"key": "update2"}, "$unset": bson.M{"other": 1}}, "o2": bson.M{"_id": toUpdateID}},
+		{"v": 2, "op": "d", "ns": "throttle.test", "o": bson.M{"_id": toRemoveID}, "b": true},
+		// Updating and removing docs that don't exist doesn't fail
+		{"v": 2, "op": "u", "ns": "throttle.test", "o": bson.M{"$set": bson.M{"key": "missing"}}, "o2": bson.M{"_id": bson.NewObjectId()}},
+		{"v": 2, "op": "d", "ns": "throttle.test", "o": bson.M{"_id": toRemoveID}, "b": true},
+	}
+	for _, entry := range entries {
+		raw, err := bson.Marshal(entry)
+		assert.NoError(t, err)
+		buffer.Write(raw)
+	}
+
+	result, err := Run(context.Background(), Options{Input: buffer, Target: memory})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result.Applied)
+
+	assert.Equal(t, 2, memory.Count("throttle.test"))
+	assert.Equal(t, bson.M{"_id": toInsertID, "key": "insert"}, memory.Find("throttle.test", toInsertID))
+	assert.Equal(t, bson.M{"_id": toUpdateID, "key": "update2"}, memory.Find("throttle.test", toUpdateID))
+	assert.Nil(t, memory.Find("throttle.test", toRemoveID))
+}
+
+// unlimited is a custom Limiter that never waits
+type unlimited struct{}
+
+func (unlimited) Wait(ctx context.Context) error { return ctx.Err() }
+
+func TestRunTargetRateMetric(t *testing.T) {
+	metrics.TargetRate.Set(0)
+	_, err := Run(context.Background(), Options{Input: bytes.NewReader(createInsert(t)), Target: target.NewMemory(), OpsPerSecond: 50, Limiter: unlimited{}})
+	assert.NoError(t, err)
+	// OpsPerSecond is ignored when there's a Limiter, so it isn't reported either
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.TargetRate))
+
+	_, err = Run(context.Background(), Options{Input: bytes.NewReader(createInsert(t)), Target: target.NewMemory(), OpsPerSecond: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), testutil.ToFloat64(metrics.TargetRate))
+}
+
+func TestRunOpsFile(t *testing.T) {
+	memory := target.NewMemory()
+	id := bson.NewObjectId()
+	buffer := bytes.NewBufferString("")
+	w := operation.NewWriter(buffer, operation.BSON)
+	assert.NoError(t, w.Write(operation.Op{ID: id.Hex(), Type: "insert", Namespace: "throttle.test", Obj: bson.M{"_id": id, "key": "insert"}}))
+	assert.NoError(t, w.Write(operation.Op{ID: id.Hex(), Type: "update", Namespace: "throttle.test", Obj: bson.M{"$set": bson.M{"key": "update"}}}))
+
+	result, err := Run(context.Background(), Options{Input: buffer, Target: memory, Decode: operation.Unmarshal})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Applied)
+	assert.Equal(t, bson.M{"_id": id, "key": "update"}, memory.Find("throttle.test", id))
+}
+
+func TestRunCheckpoint(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	for i, entry := range []bson.M{
+		{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": bson.NewObjectId()}},
+		{"v": 2, "op": "c", "ns": "throttle.$cmd", "o": bson.M{"create": "test"}},
+		{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": bson.NewObjectId()}},
+	} {
+		entry["ts"] = bson.MongoTimestamp(int64(i+1) << 32)
+		raw, err := bson.Marshal(entry)
+		assert.NoError(t, err)
+		buffer.Write(raw)
+	}
+
+	checkpoints := []bson.MongoTimestamp{}
+	_, err := Run(context.Background(), Options{
+		Input:   buffer,
+		Target:  target.NewMemory(),
+		OnError: ContinueOnError(nil, 0),
+		Checkpoint: func(entry []byte) error {
+			var doc struct {
+				Timestamp bson.MongoTimestamp `bson:"ts"`
+			}
+			assert.NoError(t, bson.Unmarshal(entry, &doc))
+			checkpoints = append(checkpoints, doc.Timestamp)
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	// Entries that failed and were skipped are done too
+	assert.Equal(t, []bson.MongoTimestamp{1 << 32, 2 << 32, 3 << 32}, checkpoints)
+}
@@ -0,0 +1,115 @@
+package apply
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Clever/mongo-op-throttler/deadletter"
+	"github.com/Clever/mongo-op-throttler/operation"
+	// Use custom scanner with higher length limitation
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+)
+
+// DryRunReport summarizes what applying an oplog would do
+type DryRunReport struct {
+	// Entries is the number of oplog entries read
+	Entries int
+	// NoOps is the number of entries that don't result in any operation, for example index creations
+	NoOps int
+	// Filtered is the number of operations a transformer or filter would drop
+	Filtered int
+	// Ops is the number of operations that would be applied
+	Ops int
+	// Counts is the number of operations that would be applied, by namespace and then by type
+	Counts map[string]map[string]int
+	// Errors has every entry that couldn't be converted to a valid operation
+	Errors []EntryError
+}
+
+// DryRun reads every entry in opts.Input and checks that it can be converted to an
+// operation that applyOp understands, without touching a database. It runs the same
+// Transformer and Filters that Run would. Unlike Run it keeps going after a bad entry so
+// that it can report all of them. The returned error is only set if the input itself
+// can't be read.
+func DryRun(opts Options) (*DryRunReport, error) {
+	report := &DryRunReport{Counts: map[string]map[string]int{}}
+	opScanner := bsonScanner.NewUnchecked(opts.Input)
+	decode := decoder(opts)
+
+	var offset int64
+	for opScanner.Scan() {
+		report.Entries++
+		offset = opScanner.Offset()
+
+		op, err := decode(opScanner.Bytes())
+		if err != nil {
+			report.Errors = append(report.Errors, EntryError{Offset: offset, Stage: deadletter.StageConvert, Err: err})
+			continue
+		}
+		if op == nil {
+			report.NoOps++
+			continue
+		}
+
+		ops := []operation.Op{*op}
+		if opts.Transformer != nil {
+			if ops, err = opts.Transformer.Transform(*op); err != nil {
+				report.Errors = append(report.Errors, EntryError{Offset: offset, Stage: deadletter.StageTransform, Namespace: op.Namespace, Err: err})
+				continue
+			}
+			if len(ops) == 0 {
+				report.Filtered++
+			}
+		}
+
+		for _, op := range ops {
+			if !passesFilters(op, opts.Filters) {
+				report.Filtered++
+				continue
+			}
+			if err := ValidateOp(op); err != nil {
+				report.Errors = append(report.Errors, EntryError{Offset: offset, Stage: deadletter.StageApply, Namespace: op.Namespace, Err: err})
+				continue
+			}
+			if report.Counts[op.Namespace] == nil {
+				report.Counts[op.Namespace] = map[string]int{}
+			}
+			report.Counts[op.Namespace][op.Type]++
+			report.Ops++
+		}
+	}
+
+	if err := opScanner.Err(); err != nil {
+		return report, fmt.Errorf("Error reading oplog after offset %d: %s", offset, err.Error())
+	}
+	return report, nil
+}
+
+// Print writes a human readable version of the report
+func (r *DryRunReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Read %d oplog entries: %d ops to apply, %d no-ops, %d filtered, %d errors\n",
+		r.Entries, r.Ops, r.NoOps, r.Filtered, len(r.Errors))
+
+	namespaces := []string{}
+	for namespace := range r.Counts {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nnamespace\tinsert\tupdate\tremove")
+	for _, namespace := range namespaces {
+		counts := r.Counts[namespace]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", namespace, counts["insert"], counts["update"], counts["remove"])
+	}
+	tw.Flush()
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		for _, err := range r.Errors {
+			fmt.Fprintf(w, "  %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package apply
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDryRun(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	buffer.Write(createInsert(t))
+
+	// A command, which convert doesn't support
+	commandOffset := buffer.Len()
+	command, err := bson.Marshal(bson.M{"v": 2, "op": "c", "ns": "throttle.$cmd", "o": bson.M{"create": "test"}})
+	assert.NoError(t, err)
+	buffer.Write(command)
+
+	// An index creation, which is a no-op
+	index, err := bson.Marshal(bson.M{"v": 2, "op": "i", "ns": "throttle.system.indexes", "o": bson.M{"key": bson.M{"val": 1}}})
+	assert.NoError(t, err)
+	buffer.Write(index)
+
+	// An insert with a string _id, which converts but can't be applied
+	stringIDOffset := buffer.Len()
+	stringID, err := bson.Marshal(bson.M{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": "stringId"}})
+	assert.NoError(t, err)
+	buffer.Write(stringID)
+
+	remove, err := bson.Marshal(bson.M{"v": 2, "op": "d", "ns": "throttle.other", "b": true, "o": bson.M{"_id": bson.NewObjectId()}})
+	assert.NoError(t, err)
+	buffer.Write(remove)
+
+	report, err := DryRun(Options{Input: buffer})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, report.Entries)
+	assert.Equal(t, 1, report.NoOps)
+	assert.Equal(t, 2, report.Ops)
+	assert.Equal(t, map[string]map[string]int{
+		"throttle.test":  {"insert": 1},
+		"throttle.other": {"remove": 1},
+	}, report.Counts)
+
+	assert.Equal(t, 2, len(report.Errors))
+	assert.Equal(t, int64(commandOffset), report.Errors[0].Offset)
+	assert.Equal(t, "Unknown op type c", report.Errors[0].Err.Error())
+	assert.Equal(t, int64(stringIDOffset), report.Errors[1].Offset)
+	assert.Equal(t, "Invalid ID: stringId", report.Errors[1].Err.Error())
+}
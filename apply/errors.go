@@ -0,0 +1,55 @@
+package apply
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Clever/mongo-op-throttler/deadletter"
+)
+
+// EntryError is an error for a single oplog entry, along with where the entry starts in the input
+type EntryError struct {
+	Offset int64
+	// Stage is where the entry failed, one of the deadletter.Stage constants
+	Stage string
+	// Namespace is the namespace of the entry or operation that failed, if it's known
+	Namespace string
+	// Raw is the original oplog entry. It's only valid until the ErrorHandler returns.
+	Raw []byte
+	Err error
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err.Error())
+}
+
+// ErrorHandler decides what happens when an entry fails to convert, transform or apply.
+// Returning nil skips the entry and keeps replaying. Returning an error stops the replay
+// with that error.
+type ErrorHandler func(err EntryError) error
+
+// stopOnError is the default ErrorHandler, which stops at the first failure
+func stopOnError(err EntryError) error {
+	return err.Err
+}
+
+// ContinueOnError returns an ErrorHandler that logs and skips failed entries. If deadLetter
+// is set each failed entry is written to it. If maxErrors is positive the replay stops once
+// there have been that many failures.
+func ContinueOnError(deadLetter *deadletter.Writer, maxErrors int) ErrorHandler {
+	failures := 0
+	return func(e EntryError) error {
+		failures++
+		log.Printf("Skipping oplog entry at offset %d that failed to %s: %s", e.Offset, e.Stage, e.Err.Error())
+		if deadLetter != nil {
+			entry := deadletter.Entry{Offset: e.Offset, Namespace: e.Namespace, Stage: e.Stage, Error: e.Err.Error(), Raw: e.Raw}
+			if err := deadLetter.Write(entry); err != nil {
+				return err
+			}
+		}
+		if maxErrors > 0 && failures >= maxErrors {
+			return fmt.Errorf("Stopping after %d failures, the last was %s", failures, e.Err.Error())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package apply
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter controls how fast operations are applied
+type Limiter interface {
+	// Wait blocks until the next operation can be applied. It returns an error if ctx is
+	// done first.
+	Wait(ctx context.Context) error
+}
+
+// rated is implemented by Limiters with a fixed rate, which Run reports as the target rate
+type rated interface {
+	Rate() float64
+}
+
+// rateLimiter spaces operations out so that on average there are at most opsPerSecond of them
+// a second, measured from the first one. Falling behind, for example because of a slow
+// operation, lets the following operations go through without waiting until it catches up.
+type rateLimiter struct {
+	opsPerSecond float64
+	start        time.Time
+	numOps       int64
+}
+
+// NewRateLimiter returns a Limiter that allows at most opsPerSecond operations per second.
+// Zero or less means there's no limit. It isn't safe for concurrent use.
+func NewRateLimiter(opsPerSecond float64) Limiter {
+	return &rateLimiter{opsPerSecond: opsPerSecond}
+}
+
+// Rate is the maximum number of operations per second, or zero or less if there's no limit
+func (l *rateLimiter) Rate() float64 {
+	return l.opsPerSecond
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l.opsPerSecond <= 0 {
+		return ctx.Err()
+	}
+	if l.numOps == 0 {
+		l.start = time.Now()
+	}
+	expectedElapsed := time.Duration(float64(l.numOps) / l.opsPerSecond * float64(time.Second))
+	l.numOps++
+
+	timeToWait := expectedElapsed - time.Now().Sub(l.start)
+	if timeToWait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(timeToWait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,38 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, limiter.Wait(context.Background()))
+	}
+	// The first op goes immediately and each one after waits 50ms
+	elapsed := time.Now().Sub(start)
+	assert.True(t, elapsed >= 200*time.Millisecond && elapsed < 300*time.Millisecond, "took %s", elapsed)
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	limiter := NewRateLimiter(0.01)
+	assert.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx))
+}
+
+func TestUnlimitedRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(0)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, limiter.Wait(context.Background()))
+	}
+	assert.True(t, time.Now().Sub(start) < 100*time.Millisecond)
+}
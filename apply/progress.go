@@ -0,0 +1,140 @@
+package apply
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultProgressInterval is how often progress is logged if Options.ProgressInterval isn't set
+const DefaultProgressInterval = 30 * time.Second
+
+// Progress is a snapshot of how far along a replay is
+type Progress struct {
+	// Entries is the number of oplog entries read so far
+	Entries int
+	// Applied is the number of operations applied so far
+	Applied int
+	// NoOps is the number of entries skipped because they don't result in an operation
+	NoOps int
+	// Filtered is the number of operations dropped by a transformer or filter
+	Filtered int
+	// Failed is the number of entries that failed to convert or transform plus the number of
+	// operations that failed to apply. It can only be above one if Options.OnError skips failures.
+	Failed int
+	// Retries is the number of times operations were retried after transient errors
+	Retries int
+	// LastTimestamp is the oplog timestamp of the last operation applied, if it had one
+	LastTimestamp bson.MongoTimestamp
+	// Bytes is the number of bytes of input consumed so far
+	Bytes int64
+	// TotalBytes is the size of the input, or zero if it isn't known
+	TotalBytes int64
+	// Elapsed is the time since the replay started
+	Elapsed time.Duration
+	// Rate is the number of operations applied per second since the previous snapshot
+	Rate float64
+}
+
+// Percent is how much of the input has been consumed, or -1 if the size of the input isn't known
+func (p Progress) Percent() float64 {
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	return float64(p.Bytes) / float64(p.TotalBytes) * 100
+}
+
+// ETA estimates how much longer the replay will take from the average speed at which input has
+// been consumed so far. It returns -1 if there isn't enough information to estimate.
+func (p Progress) ETA() time.Duration {
+	if p.TotalBytes <= 0 || p.Bytes <= 0 {
+		return -1
+	}
+	remaining := float64(p.TotalBytes-p.Bytes) / float64(p.Bytes) * float64(p.Elapsed)
+	return time.Duration(remaining).Round(time.Second)
+}
+
+func (p Progress) String() string {
+	s := fmt.Sprintf("Processed %d ops", p.Applied)
+	if p.TotalBytes > 0 {
+		s += fmt.Sprintf(" (%.1f%%, %d of %d bytes)", p.Percent(), p.Bytes, p.TotalBytes)
+	}
+	s += fmt.Sprintf(", %.1f ops/sec", p.Rate)
+	if eta := p.ETA(); eta >= 0 {
+		s += fmt.Sprintf(", ETA %s", eta)
+	}
+	return s
+}
+
+// Summary describes a finished replay
+func (p Progress) Summary() string {
+	avg := 0.0
+	if p.Elapsed > 0 {
+		avg = float64(p.Applied) / p.Elapsed.Seconds()
+	}
+	return fmt.Sprintf("Applied %d ops from %d oplog entries (%d bytes), skipped %d no-ops, filtered %d, %d failed, %d retries, in %s (%.1f ops/sec)",
+		p.Applied, p.Entries, p.Bytes, p.NoOps, p.Filtered, p.Failed, p.Retries, p.Elapsed.Round(time.Millisecond), avg)
+}
+
+// progressTracker keeps the running counts for a replay and reports them every interval
+type progressTracker struct {
+	current     Progress
+	interval    time.Duration
+	onProgress  func(Progress)
+	description string
+	start       time.Time
+	lastReport  time.Time
+	lastApplied int
+}
+
+func newProgressTracker(totalBytes int64, interval time.Duration, onProgress func(Progress)) *progressTracker {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	if onProgress == nil {
+		onProgress = func(p Progress) {
+			log.Print(p.String())
+		}
+	}
+	now := time.Now()
+	return &progressTracker{
+		current:    Progress{TotalBytes: totalBytes},
+		interval:   interval,
+		onProgress: onProgress,
+		start:      now,
+		lastReport: now,
+	}
+}
+
+// maybeReport reports the current progress if it's been at least interval since the last time
+func (t *progressTracker) maybeReport() {
+	now := time.Now()
+	if now.Sub(t.lastReport) < t.interval {
+		return
+	}
+	t.onProgress(t.snapshot(now))
+	t.lastReport = now
+	t.lastApplied = t.current.Applied
+}
+
+func (t *progressTracker) snapshot(now time.Time) Progress {
+	p := t.current
+	p.Elapsed = now.Sub(t.start)
+	if sinceLast := now.Sub(t.lastReport); sinceLast > 0 {
+		p.Rate = float64(p.Applied-t.lastApplied) / sinceLast.Seconds()
+	}
+	return p
+}
+
+// finish logs a summary of the whole replay and returns the final progress
+func (t *progressTracker) finish() Progress {
+	p := t.snapshot(time.Now())
+	if t.description != "" {
+		log.Printf("%s with %s", p.Summary(), t.description)
+	} else {
+		log.Print(p.Summary())
+	}
+	return p
+}
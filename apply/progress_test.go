@@ -0,0 +1,27 @@
+package apply
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressPercentAndETA(t *testing.T) {
+	p := Progress{Applied: 100, Bytes: 250, TotalBytes: 1000, Elapsed: 10 * time.Second, Rate: 10}
+	assert.Equal(t, 25.0, p.Percent())
+	assert.Equal(t, 30*time.Second, p.ETA())
+	assert.Equal(t, "Processed 100 ops (25.0%, 250 of 1000 bytes), 10.0 ops/sec, ETA 30s", p.String())
+}
+
+func TestProgressUnknownSize(t *testing.T) {
+	p := Progress{Applied: 100, Bytes: 250, Elapsed: 10 * time.Second, Rate: 10}
+	assert.Equal(t, -1.0, p.Percent())
+	assert.Equal(t, time.Duration(-1), p.ETA())
+	assert.Equal(t, "Processed 100 ops, 10.0 ops/sec", p.String())
+}
+
+func TestProgressSummary(t *testing.T) {
+	p := Progress{Entries: 13, Applied: 10, NoOps: 2, Filtered: 4, Failed: 1, Retries: 3, Bytes: 1200, Elapsed: 5 * time.Second}
+	assert.Equal(t, "Applied 10 ops from 13 oplog entries (1200 bytes), skipped 2 no-ops, filtered 4, 1 failed, 3 retries, in 5s (2.0 ops/sec)", p.Summary())
+}
@@ -0,0 +1,181 @@
+package apply
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Clever/mongo-op-throttler/metrics"
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/target"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2"
+)
+
+// RetryPolicy controls how operations that fail with transient errors are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of times to retry an operation. Zero disables retries.
+	MaxRetries int
+	// InitialBackoff is the longest wait before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries. Defaults to 10s.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy rides out a primary election, which typically takes around ten seconds
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 8, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// backoff returns how long to wait before the given retry, counting from zero. The wait
+// doubles with each retry and is jittered so many replays don't retry in lockstep.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+
+	wait := initial
+	for i := 0; i < retry && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	// Wait somewhere between half and all of the backoff
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// transientCodes are Mongo error codes for failures that are expected to go away on their own,
+// mostly from replica set elections and network problems
+var transientCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	64:    true, // WriteConcernFailed
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// transientMessages are substrings of errors mgo returns without a code for the same kinds of failures
+var transientMessages = []string{
+	"not master",
+	"no reachable servers",
+	"node is recovering",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"Closed explicitly",
+}
+
+// IsTransient reports whether an error from mgo or the Mongo driver is likely to go away if the operation is retried,
+// as opposed to something wrong with the operation itself
+func IsTransient(err error) bool {
+	if err == nil || err == mgo.ErrNotFound {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	switch t := err.(type) {
+	case mongo.ServerError:
+		if t.HasErrorLabel("RetryableWriteError") {
+			return true
+		}
+		for code := range transientCodes {
+			if t.HasErrorCode(code) {
+				return true
+			}
+		}
+	case *mgo.LastError:
+		if transientCodes[t.Code] {
+			return true
+		}
+	case *mgo.QueryError:
+		if transientCodes[t.Code] {
+			return true
+		}
+	}
+
+	msg := err.Error()
+	for _, transient := range transientMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresher is implemented by targets, like target.Mongo, that can reconnect between retries
+type refresher interface {
+	Refresh()
+}
+
+// withRetries calls fn until it succeeds, fails with an error that isn't transient, or runs out
+// of retries. Before each retry it refreshes the session, which makes mgo drop sockets that may
+// be broken and find the current primary again. It stops waiting to retry once ctx is done,
+// returning the last error. It returns the number of retries it made.
+func withRetries(ctx context.Context, policy RetryPolicy, session refresher, describe string, fn func() error) (int, error) {
+	retries := 0
+	for {
+		err := fn()
+		if err == nil || !IsTransient(err) || retries >= policy.MaxRetries {
+			if err != nil && retries > 0 {
+				log.Printf("Giving up on %s after %d retries: %s", describe, retries, err.Error())
+			}
+			return retries, err
+		}
+
+		wait := policy.backoff(retries)
+		log.Printf("Retrying %s in %s (retry %d of %d) after transient error: %s",
+			describe, wait, retries+1, policy.MaxRetries, err.Error())
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("Giving up on %s after %d retries since the replay was stopped: %s", describe, retries, err.Error())
+			return retries, err
+		case <-timer.C:
+		}
+		retries++
+		if session != nil {
+			session.Refresh()
+		}
+	}
+}
+
+// applyOpWithRetries applies an op, retrying transient errors according to the policy, and
+// records the outcome in the metrics. It returns the number of retries it made.
+func applyOpWithRetries(ctx context.Context, op operation.Op, t target.Target, policy RetryPolicy) (int, error) {
+	start := time.Now()
+	r, _ := t.(refresher)
+	retries, err := withRetries(ctx, policy, r, op.Type+" "+op.Namespace+" "+op.ID, func() error {
+		return applyOp(op, t)
+	})
+	if retries > 0 {
+		metrics.OpsRetried.WithLabelValues(op.Namespace, op.Type).Add(float64(retries))
+	}
+	observeApply(op, start, err)
+	return retries, err
+}
@@ -0,0 +1,109 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2"
+)
+
+// fakeSession stands in for an *mgo.Session. Each call to apply returns the next injected error.
+type fakeSession struct {
+	errs      []error
+	calls     int
+	refreshes int
+}
+
+func (s *fakeSession) Refresh() {
+	s.refreshes++
+}
+
+func (s *fakeSession) apply() error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+var fastRetries = RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	session := &fakeSession{errs: []error{
+		io.EOF,
+		&mgo.LastError{Code: 10107, Err: "not master"},
+	}}
+	retries, err := withRetries(context.Background(), fastRetries, session, "test op", session.apply)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, retries)
+	assert.Equal(t, 3, session.calls)
+	assert.Equal(t, 2, session.refreshes)
+}
+
+func TestDoesNotRetryPermanentErrors(t *testing.T) {
+	session := &fakeSession{errs: []error{&mgo.LastError{Code: 11000, Err: "E11000 duplicate key error"}}}
+	retries, err := withRetries(context.Background(), fastRetries, session, "test op", session.apply)
+	assert.Error(t, err)
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, 1, session.calls)
+	assert.Equal(t, 0, session.refreshes)
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	session := &fakeSession{errs: []error{io.EOF, io.EOF, io.EOF, io.EOF, io.EOF}}
+	retries, err := withRetries(context.Background(), fastRetries, session, "test op", session.apply)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, retries)
+	assert.Equal(t, 4, session.calls)
+}
+
+func TestZeroPolicyDoesNotRetry(t *testing.T) {
+	session := &fakeSession{errs: []error{io.EOF}}
+	retries, err := withRetries(context.Background(), RetryPolicy{}, session, "test op", session.apply)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, retries)
+}
+
+func TestStopsRetryingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	session := &fakeSession{errs: []error{io.EOF, io.EOF}}
+	slow := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	start := time.Now()
+	retries, err := withRetries(ctx, slow, session, "test op", session.apply)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, 1, session.calls)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(io.EOF))
+	assert.True(t, IsTransient(&mgo.LastError{Code: 11602}))
+	assert.True(t, IsTransient(&mgo.QueryError{Code: 13435}))
+	assert.True(t, IsTransient(errors.New("no reachable servers")))
+	assert.True(t, IsTransient(mongo.CommandError{Code: 189, Message: "primary stepped down"}))
+	assert.True(t, IsTransient(mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}))
+	assert.True(t, IsTransient(mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}))
+	assert.False(t, IsTransient(nil))
+	assert.False(t, IsTransient(mgo.ErrNotFound))
+	assert.False(t, IsTransient(&mgo.LastError{Code: 11000}))
+	assert.False(t, IsTransient(mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}))
+	assert.False(t, IsTransient(errors.New("Invalid ID: bad")))
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		wait := policy.backoff(retry)
+		assert.True(t, wait >= max/2 && wait <= max, "retry %d waited %s", retry, wait)
+	}
+}
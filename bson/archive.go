@@ -0,0 +1,208 @@
+package bson
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ArchiveOplog is the namespace mongodump --archive --oplog stores the oplog under
+const ArchiveOplog = "oplog"
+
+// archiveMagic starts every mongodump archive
+var archiveMagic = []byte{0x6d, 0xe2, 0x99, 0x81}
+
+// archiveTerminator ends the prelude and each block of documents
+const archiveTerminator = -1
+
+// IsArchive reports whether data starts like a mongodump archive. It can't be confused
+// with raw BSON since as a document size the magic number would be negative.
+func IsArchive(data []byte) bool {
+	return len(data) >= len(archiveMagic) && string(data[:len(archiveMagic)]) == string(archiveMagic)
+}
+
+// archiveNamespaceHeader starts each block of documents in the body of an archive
+type archiveNamespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+func archiveNamespace(database, collection string) string {
+	if database == "" {
+		return collection
+	}
+	return database + "." + collection
+}
+
+// ArchiveReader reads the documents of one namespace out of a mongodump --archive stream.
+// An archive is a magic number, a header document, a document describing each collection,
+// and then blocks of documents from the different collections interleaved. Each block is a
+// header document naming the collection followed by its documents. The documents are
+// returned one after another like a plain mongodump file, so ArchiveReader can be passed
+// to New.
+type ArchiveReader struct {
+	r         *bufio.Reader
+	namespace string
+	started   bool
+	pending   []byte
+	seen      map[string]bool
+	found     bool
+	crc       hash.Hash64
+	err       error
+}
+
+// NewArchiveReader returns a reader of the documents in the given namespace, for example
+// "clever.sections" or ArchiveOplog
+func NewArchiveReader(r io.Reader, namespace string) *ArchiveReader {
+	return &ArchiveReader{
+		r:         bufio.NewReader(r),
+		namespace: namespace,
+		seen:      map[string]bool{},
+		crc:       crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}
+}
+
+// Read implements io.Reader
+func (a *ArchiveReader) Read(p []byte) (int, error) {
+	for len(a.pending) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		a.pending, a.err = a.next()
+	}
+	n := copy(p, a.pending)
+	a.pending = a.pending[n:]
+	return n, nil
+}
+
+// readDoc reads a single document, or returns nil at a terminator
+func (a *ArchiveReader) readDoc() ([]byte, error) {
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(a.r, sizeBytes); err != nil {
+		return nil, err
+	}
+	size := int32(binary.LittleEndian.Uint32(sizeBytes))
+	if size == archiveTerminator {
+		return nil, nil
+	}
+	if size < 5 || size > MaxScanTokenSize {
+		return nil, fmt.Errorf("Invalid document size %d in archive", size)
+	}
+	doc := make([]byte, size)
+	copy(doc, sizeBytes)
+	if _, err := io.ReadFull(a.r, doc[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// readPrelude checks the magic number and reads past the header and collection metadata
+func (a *ArchiveReader) readPrelude() error {
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(a.r, magic); err != nil || !IsArchive(magic) {
+		return errors.New("Input is not a mongodump archive")
+	}
+	// The header has versions we don't need
+	if _, err := a.readDoc(); err != nil {
+		return fmt.Errorf("Error reading archive header %s", err)
+	}
+	for {
+		doc, err := a.readDoc()
+		if err != nil {
+			return fmt.Errorf("Error reading archive prelude %s", err)
+		}
+		if doc == nil {
+			return nil
+		}
+		var metadata archiveNamespaceHeader
+		if err := bson.Unmarshal(doc, &metadata); err != nil {
+			return fmt.Errorf("Error reading archive prelude %s", err)
+		}
+		a.seen[archiveNamespace(metadata.Database, metadata.Collection)] = true
+	}
+}
+
+// next returns the next block of documents in the selected namespace, or an error once
+// there aren't any more
+func (a *ArchiveReader) next() ([]byte, error) {
+	if !a.started {
+		a.started = true
+		if err := a.readPrelude(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		doc, err := a.readDoc()
+		if err == io.EOF {
+			return nil, a.finish()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading archive block header %s", err)
+		}
+		if doc == nil {
+			return nil, errors.New("Unexpected terminator in archive")
+		}
+		var header archiveNamespaceHeader
+		if err := bson.Unmarshal(doc, &header); err != nil {
+			return nil, fmt.Errorf("Error reading archive block header %s", err)
+		}
+		namespace := archiveNamespace(header.Database, header.Collection)
+		a.seen[namespace] = true
+		selected := namespace == a.namespace
+		if selected {
+			a.found = true
+		}
+
+		block := []byte{}
+		for {
+			doc, err := a.readDoc()
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, fmt.Errorf("Error reading %s from archive %s", namespace, err)
+			}
+			if doc == nil {
+				break
+			}
+			if selected {
+				a.crc.Write(doc)
+				block = append(block, doc...)
+			}
+		}
+
+		if selected && header.EOF && int64(a.crc.Sum64()) != header.CRC {
+			return nil, fmt.Errorf("Checksum mismatch for %s in archive", namespace)
+		}
+		if len(block) > 0 {
+			return block, nil
+		}
+	}
+}
+
+// finish is called at the end of the archive. It's an error if the namespace wasn't in it.
+func (a *ArchiveReader) finish() error {
+	if a.found {
+		return io.EOF
+	}
+	namespaces := []string{}
+	for namespace := range a.seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return fmt.Errorf("Namespace %s not found in archive, it has %s", a.namespace, strings.Join(namespaces, ", "))
+}
@@ -0,0 +1,122 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// archiveWriter builds archives the way mongodump --archive lays them out
+type archiveWriter struct {
+	buf  bytes.Buffer
+	crcs map[string]uint64
+}
+
+func newArchiveWriter(t *testing.T, namespaces ...[2]string) *archiveWriter {
+	w := &archiveWriter{crcs: map[string]uint64{}}
+	w.buf.Write(archiveMagic)
+	w.doc(t, bson.M{"version": "0.1", "server_version": "4.4.0", "tool_version": "100.3.0"})
+	for _, ns := range namespaces {
+		w.doc(t, bson.M{"db": ns[0], "collection": ns[1], "metadata": "", "size": 0})
+	}
+	w.terminator()
+	return w
+}
+
+func (w *archiveWriter) doc(t *testing.T, doc interface{}) []byte {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal("Got error", err)
+	}
+	w.buf.Write(raw)
+	return raw
+}
+
+func (w *archiveWriter) terminator() {
+	binary.Write(&w.buf, binary.LittleEndian, int32(-1))
+}
+
+func (w *archiveWriter) block(t *testing.T, db, collection string, docs ...bson.M) []byte {
+	w.doc(t, bson.M{"db": db, "collection": collection, "EOF": false, "CRC": int64(0)})
+	var written []byte
+	table := crc64.MakeTable(crc64.ECMA)
+	for _, doc := range docs {
+		raw := w.doc(t, doc)
+		w.crcs[db+"."+collection] = crc64.Update(w.crcs[db+"."+collection], table, raw)
+		written = append(written, raw...)
+	}
+	w.terminator()
+	return written
+}
+
+func (w *archiveWriter) eof(t *testing.T, db, collection string) {
+	w.doc(t, bson.M{"db": db, "collection": collection, "EOF": true, "CRC": int64(w.crcs[db+"."+collection])})
+	w.terminator()
+}
+
+func TestArchiveReader(t *testing.T) {
+	w := newArchiveWriter(t, [2]string{"clever", "sections"}, [2]string{"", "oplog"})
+	w.block(t, "clever", "sections", bson.M{"_id": 1})
+	expected := w.block(t, "", "oplog", bson.M{"op": "i", "ns": "clever.sections"}, bson.M{"op": "u", "ns": "clever.sections"})
+	w.eof(t, "clever", "sections")
+	expected = append(expected, w.block(t, "", "oplog", bson.M{"op": "d", "ns": "clever.sections"})...)
+	w.eof(t, "", "oplog")
+	archive := w.buf.Bytes()
+
+	if !IsArchive(archive) {
+		t.Fatal("Expected the archive to be detected")
+	}
+
+	read, err := ioutil.ReadAll(NewArchiveReader(bytes.NewReader(archive), ArchiveOplog))
+	if err != nil {
+		t.Fatal("Got error", err)
+	}
+	if !bytes.Equal(expected, read) {
+		t.Fatal("Didn't read the expected oplog entries")
+	}
+
+	// The documents can be scanned like a plain dump
+	scanner := New(NewArchiveReader(bytes.NewReader(archive), "clever.sections"))
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	if scanner.Err() != nil || count != 1 {
+		t.Fatalf("Expected 1 document, got %d and error %v", count, scanner.Err())
+	}
+}
+
+func TestArchiveReaderErrors(t *testing.T) {
+	w := newArchiveWriter(t, [2]string{"clever", "sections"})
+	w.block(t, "clever", "sections", bson.M{"_id": 1})
+	w.eof(t, "clever", "sections")
+	archive := w.buf.Bytes()
+
+	_, err := ioutil.ReadAll(NewArchiveReader(bytes.NewReader(archive), ArchiveOplog))
+	if err == nil || err.Error() != "Namespace oplog not found in archive, it has clever.sections" {
+		t.Fatal("Expected a missing namespace error, got", err)
+	}
+
+	_, err = ioutil.ReadAll(NewArchiveReader(bytes.NewReader(archive[:len(archive)-10]), "clever.sections"))
+	if err == nil {
+		t.Fatal("Expected an error reading a truncated archive")
+	}
+
+	_, err = ioutil.ReadAll(NewArchiveReader(bytes.NewReader([]byte("not an archive")), ArchiveOplog))
+	if err == nil || err.Error() != "Input is not a mongodump archive" {
+		t.Fatal("Expected a not an archive error, got", err)
+	}
+
+	w = newArchiveWriter(t, [2]string{"clever", "sections"})
+	w.block(t, "clever", "sections", bson.M{"_id": 1})
+	w.crcs["clever.sections"]++
+	w.eof(t, "clever", "sections")
+	_, err = ioutil.ReadAll(NewArchiveReader(bytes.NewReader(w.buf.Bytes()), "clever.sections"))
+	if err == nil || err.Error() != "Checksum mismatch for clever.sections in archive" {
+		t.Fatal("Expected a checksum error, got", err)
+	}
+}
@@ -3,30 +3,343 @@ package bson
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
+// MaxDocumentSize is the largest document New accepts. Mongo limits documents to 16MB, but
+// oplog entries can be a little larger since they wrap a document.
+const MaxDocumentSize = 16*1024*1024 + 16*1024
+
+// minDocumentSize is the size of an empty document: the length and the terminating null
+const minDocumentSize = 5
+
+// maxNesting is how deep documents can be nested, which is Mongo's limit with some room to spare
+const maxNesting = 200
+
+// CorruptError is a document in the input that isn't valid BSON
+type CorruptError struct {
+	// Offset is where the document starts in the input
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("Corrupt BSON document at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Skip is a range of corrupt input that a recovering Scanner skipped over
+type Skip struct {
+	Offset int64
+	Length int64
+	// Reason is why the document at Offset was invalid
+	Reason string
+}
+
 func needMoreData() (int, []byte, error) { return 0, nil, nil }
 
 // mongodump outputs collections as binary files with all the documents appended together.
-// The first four bytes are the size of the full document, including the size bytes.
+// The first four bytes are the size of the full document, including the size bytes. Each
+// document is checked before it's returned, and the Scanner stops with a *CorruptError at
+// the first one that isn't valid BSON.
 func New(r io.Reader) *Scanner {
+	return newDocumentScanner(r, nil)
+}
+
+// NewRecovering is like New, but instead of stopping at corrupt data it skips forward to the
+// next valid document, calling onSkip with each range of the input it skipped
+func NewRecovering(r io.Reader, onSkip func(Skip)) *Scanner {
+	if onSkip == nil {
+		onSkip = func(Skip) {}
+	}
+	return newDocumentScanner(r, onSkip)
+}
+
+// NewUnchecked splits r into documents by their size prefixes without checking what's in
+// them, for input that's already been checked like the output of an input.Merger. It only
+// stops with a *CorruptError if a size is out of range or the input ends part way through a
+// document.
+func NewUnchecked(r io.Reader) *Scanner {
 	scanner := NewScanner(r)
+	scanner.maxTokenSize = MaxDocumentSize
+	var offset int64
 	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
-		if len(data) < 4 {
+		size, reason := documentSize(data, atEOF)
+		if reason != "" {
+			return 0, nil, &CorruptError{Offset: offset, Reason: reason}
+		}
+		if size == 0 {
 			return needMoreData()
 		}
+		offset += int64(size)
+		return size, data[:size], nil
+	})
+	return scanner
+}
 
-		var size int32
-		if err := binary.Read(bytes.NewBuffer(data[0:4]), binary.LittleEndian, &size); err != nil {
-			return 0, nil, err
+func newDocumentScanner(r io.Reader, onSkip func(Skip)) *Scanner {
+	scanner := NewScanner(r)
+	scanner.maxTokenSize = MaxDocumentSize
+	// offset is where data starts in the input, since the Scanner always advances by what's returned
+	var offset int64
+	var skip *Skip
+	endSkip := func() {
+		if skip != nil {
+			onSkip(*skip)
+			skip = nil
 		}
-
-		if int(size) > len(data) {
-			return needMoreData()
+	}
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		size, reason := checkDocument(data, atEOF)
+		if reason == "" && size == 0 {
+			if onSkip == nil {
+				return needMoreData()
+			}
+			// Garbage can have any size, so only wait for the rest of data that starts like a
+			// document. Otherwise up to MaxDocumentSize would be buffered at every byte skipped.
+			if reason = checkStart(data); reason == "" {
+				return needMoreData()
+			}
+		}
+		if reason == "" {
+			endSkip()
+			offset += int64(size)
+			return size, data[:size], nil
 		}
 
-		return int(size), data[0:size], nil
+		if onSkip == nil {
+			return 0, nil, &CorruptError{Offset: offset, Reason: reason}
+		}
+		// Move on a byte at a time looking for the start of the next valid document
+		if skip == nil {
+			skip = &Skip{Offset: offset, Reason: reason}
+		}
+		skip.Length++
+		offset++
+		if atEOF && len(data) == 1 {
+			// The Scanner won't call again once everything's been consumed
+			endSkip()
+		}
+		return 1, nil, nil
 	})
 	return scanner
 }
+
+// checkDocument checks the document at the start of data. It returns the document's size
+// if it's valid, a reason if it isn't, or neither if it needs more data to tell.
+func checkDocument(data []byte, atEOF bool) (int, string) {
+	size, reason := documentSize(data, atEOF)
+	if size == 0 {
+		return 0, reason
+	}
+	if err := validateDocument(data[:size], 0); err != "" {
+		return 0, err
+	}
+	return size, ""
+}
+
+// documentSize reads the size of the document at the start of data like checkDocument, but
+// only checks that the size is in range and that data holds all of the document
+func documentSize(data []byte, atEOF bool) (int, string) {
+	if len(data) < 4 {
+		if atEOF {
+			return 0, fmt.Sprintf("truncated: only %d bytes are left", len(data))
+		}
+		return 0, ""
+	}
+	size := int64(int32(binary.LittleEndian.Uint32(data)))
+	if size < minDocumentSize {
+		return 0, fmt.Sprintf("size %d is less than the minimum of %d", size, minDocumentSize)
+	}
+	if size > MaxDocumentSize {
+		return 0, fmt.Sprintf("size %d is more than the maximum of %d", size, MaxDocumentSize)
+	}
+	if size > int64(len(data)) {
+		if atEOF {
+			return 0, fmt.Sprintf("truncated: the size is %d but only %d bytes are left", size, len(data))
+		}
+		return 0, ""
+	}
+	return int(size), ""
+}
+
+// maxFirstName is how far checkStart looks for the end of the first element's name. Oplog
+// entries start with short names like "ts", so anything longer is taken to be garbage.
+const maxFirstName = 1024
+
+// checkStart returns a reason if data, the start of a document whose size is in range but
+// that isn't all there yet, doesn't begin with a valid element type and name
+func checkStart(data []byte) string {
+	if len(data) < 5 {
+		return ""
+	}
+	kind := data[4]
+	if kind == 0 {
+		return "a null byte at 4 ends it early"
+	}
+	if !(kind >= 0x01 && kind <= 0x13 || kind == 0x7F || kind == 0xFF) {
+		return fmt.Sprintf("unknown type 0x%02x", kind)
+	}
+	name := data[5:]
+	if len(name) > maxFirstName {
+		name = name[:maxFirstName]
+	}
+	if bytes.IndexByte(name, 0) < 0 && len(name) == maxFirstName {
+		return "the name of the element at 4 isn't terminated"
+	}
+	return ""
+}
+
+// validateDocument checks that doc, whose size has already been checked, is made of well
+// formed elements and ends with a null byte
+func validateDocument(doc []byte, depth int) string {
+	if depth > maxNesting {
+		return fmt.Sprintf("nested more than %d levels deep", maxNesting)
+	}
+	if doc[len(doc)-1] != 0 {
+		return "it doesn't end with a null byte"
+	}
+	pos := 4
+	for {
+		if pos >= len(doc) {
+			return "it ends part way through an element"
+		}
+		kind := doc[pos]
+		if kind == 0 {
+			if pos != len(doc)-1 {
+				return fmt.Sprintf("a null byte at %d ends it early", pos)
+			}
+			return ""
+		}
+		nameEnd := cstringEnd(doc, pos+1)
+		if nameEnd < 0 {
+			return fmt.Sprintf("the name of the element at %d isn't terminated", pos)
+		}
+		size, reason := elementSize(doc, nameEnd+1, kind, depth)
+		if reason != "" {
+			return fmt.Sprintf("element %q at %d: %s", doc[pos+1:nameEnd], pos, reason)
+		}
+		pos = nameEnd + 1 + size
+	}
+}
+
+// elementSize returns the size of the value of an element of the given kind starting at pos
+func elementSize(doc []byte, pos int, kind byte, depth int) (int, string) {
+	remaining := len(doc) - 1 - pos
+	fixed := func(size int) (int, string) {
+		if size > remaining {
+			return 0, "it runs past the end of the document"
+		}
+		return size, ""
+	}
+	switch kind {
+	case 0x01, 0x09, 0x11, 0x12: // double, datetime, timestamp, int64
+		return fixed(8)
+	case 0x06, 0x0A, 0x7F, 0xFF: // undefined, null, max key, min key
+		return 0, ""
+	case 0x07: // ObjectId
+		return fixed(12)
+	case 0x08: // bool
+		if size, reason := fixed(1); reason != "" {
+			return size, reason
+		}
+		if doc[pos] > 1 {
+			return 0, fmt.Sprintf("invalid bool %d", doc[pos])
+		}
+		return 1, ""
+	case 0x10: // int32
+		return fixed(4)
+	case 0x13: // decimal128
+		return fixed(16)
+	case 0x02, 0x0D, 0x0E: // string, JavaScript, symbol
+		return stringSize(doc, pos, remaining)
+	case 0x03, 0x04: // document, array
+		if remaining < minDocumentSize {
+			return 0, "it runs past the end of the document"
+		}
+		size := int(int32(binary.LittleEndian.Uint32(doc[pos:])))
+		if size < minDocumentSize || size > remaining {
+			return 0, fmt.Sprintf("invalid embedded document size %d", size)
+		}
+		if reason := validateDocument(doc[pos:pos+size], depth+1); reason != "" {
+			return 0, reason
+		}
+		return size, ""
+	case 0x05: // binary
+		if remaining < 5 {
+			return 0, "it runs past the end of the document"
+		}
+		size := int(int32(binary.LittleEndian.Uint32(doc[pos:])))
+		if size < 0 || size+5 > remaining {
+			return 0, fmt.Sprintf("invalid binary size %d", size)
+		}
+		return size + 5, ""
+	case 0x0B: // regex, a pattern and options
+		patternEnd := cstringEnd(doc, pos)
+		if patternEnd < 0 {
+			return 0, "the pattern isn't terminated"
+		}
+		optionsEnd := cstringEnd(doc, patternEnd+1)
+		if optionsEnd < 0 {
+			return 0, "the options aren't terminated"
+		}
+		return optionsEnd + 1 - pos, ""
+	case 0x0C: // DBPointer, a string and an ObjectId
+		size, reason := stringSize(doc, pos, remaining)
+		if reason != "" {
+			return 0, reason
+		}
+		if size+12 > remaining {
+			return 0, "it runs past the end of the document"
+		}
+		return size + 12, ""
+	case 0x0F: // JavaScript with scope, a total size, a string and a document
+		if remaining < 4 {
+			return 0, "it runs past the end of the document"
+		}
+		size := int(int32(binary.LittleEndian.Uint32(doc[pos:])))
+		if size < 14 || size > remaining {
+			return 0, fmt.Sprintf("invalid code with scope size %d", size)
+		}
+		codeSize, reason := stringSize(doc, pos+4, size-4)
+		if reason != "" {
+			return 0, reason
+		}
+		scope := doc[pos+4+codeSize : pos+size]
+		if len(scope) < minDocumentSize || int(int32(binary.LittleEndian.Uint32(scope))) != len(scope) {
+			return 0, "invalid scope size"
+		}
+		if reason := validateDocument(scope, depth+1); reason != "" {
+			return 0, reason
+		}
+		return size, ""
+	default:
+		return 0, fmt.Sprintf("unknown type 0x%02x", kind)
+	}
+}
+
+// stringSize returns the size of a length prefixed, null terminated string at pos
+func stringSize(doc []byte, pos int, remaining int) (int, string) {
+	if remaining < 5 {
+		return 0, "it runs past the end of the document"
+	}
+	size := int(int32(binary.LittleEndian.Uint32(doc[pos:])))
+	if size < 1 || size+4 > remaining {
+		return 0, fmt.Sprintf("invalid string size %d", size)
+	}
+	if doc[pos+4+size-1] != 0 {
+		return 0, "the string isn't null terminated"
+	}
+	return size + 4, ""
+}
+
+// cstringEnd returns the position of the null byte ending the C string at pos, or -1 if it
+// isn't terminated before the document's own terminator
+func cstringEnd(doc []byte, pos int) int {
+	for i := pos; i < len(doc)-1; i++ {
+		if doc[i] == 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,8 +1,12 @@
 package bson
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/mgo.v2/bson"
@@ -80,3 +84,204 @@ func TestParseLargeBSON(t *testing.T) {
 	}
 
 }
+
+func TestOffsets(t *testing.T) {
+	f, err := os.Open("./testdata.bson")
+	if err != nil {
+		t.Fatal("Got error", err)
+	}
+	defer f.Close()
+
+	var expectedOffset int64
+	scanner := New(f)
+	for scanner.Scan() {
+		if scanner.Offset() != expectedOffset {
+			t.Fatalf("Expected offset %d, got %d", expectedOffset, scanner.Offset())
+		}
+		expectedOffset += int64(len(scanner.Bytes()))
+	}
+	if scanner.Err() != nil {
+		t.Fatal("Scanner error", scanner.Err())
+	}
+	if fi, _ := f.Stat(); fi.Size() != expectedOffset {
+		t.Fatalf("Expected to read the whole file (%d bytes), read %d", fi.Size(), expectedOffset)
+	}
+}
+
+func marshal(t *testing.T, doc interface{}) []byte {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal("Error marshalling", err)
+	}
+	return raw
+}
+
+func TestCorruptDocuments(t *testing.T) {
+	valid := marshal(t, bson.M{"op": "i", "o": bson.M{"_id": 1, "list": []interface{}{"a", true}}})
+	withSize := func(size int32, rest []byte) []byte {
+		doc := []byte{byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)}
+		return append(doc, rest...)
+	}
+	// A copy of valid with the last byte, which should be null, changed
+	noTerminator := append([]byte{}, valid...)
+	noTerminator[len(noTerminator)-1] = 1
+	// A document with a string whose length runs past the end
+	longString := marshal(t, bson.M{"s": "abc"})
+	longString[7] = 100
+	// A document with an element type that doesn't exist
+	badType := marshal(t, bson.M{"n": 1})
+	badType[4] = 0x42
+
+	for name, test := range map[string]struct {
+		input  []byte
+		reason string
+	}{
+		"truncated size":     {[]byte{10, 0}, "truncated: only 2 bytes are left"},
+		"truncated document": {valid[:len(valid)-3], fmt.Sprintf("truncated: the size is %d but only %d bytes are left", len(valid), len(valid)-3)},
+		"negative size":      {withSize(-20, make([]byte, 20)), "size -20 is less than the minimum of 5"},
+		"huge size":          {withSize(1<<30, make([]byte, 20)), "size 1073741824 is more than the maximum of 16793600"},
+		"no terminator":      {noTerminator, "it doesn't end with a null byte"},
+		"long string":        {longString, `element "s" at 4: invalid string size 100`},
+		"bad type":           {badType, "unknown type 0x42"},
+	} {
+		scanner := New(bytes.NewReader(append(append([]byte{}, valid...), test.input...)))
+		if !scanner.Scan() {
+			t.Fatal(name, "didn't read the valid document first", scanner.Err())
+		}
+		if scanner.Scan() {
+			t.Fatal(name, "read a corrupt document")
+		}
+		err, ok := scanner.Err().(*CorruptError)
+		if !ok {
+			t.Fatalf("%s: expected a CorruptError, got %v", name, scanner.Err())
+		}
+		if err.Offset != int64(len(valid)) {
+			t.Fatalf("%s: expected offset %d, got %d", name, len(valid), err.Offset)
+		}
+		if !strings.Contains(err.Reason, test.reason) {
+			t.Fatalf("%s: expected %q in %q", name, test.reason, err.Reason)
+		}
+	}
+}
+
+func TestRecovering(t *testing.T) {
+	first := marshal(t, bson.M{"n": 1})
+	second := marshal(t, bson.M{"n": 2, "s": "text"})
+	third := marshal(t, bson.M{"n": 3})
+	garbage := []byte("\xff\xff\xff\x7fnot bson at all")
+	input := bytes.Join([][]byte{first, garbage, second, third[:len(third)-1], third, third[:6]}, nil)
+
+	skips := []Skip{}
+	scanner := NewRecovering(bytes.NewReader(input), func(skip Skip) {
+		skips = append(skips, skip)
+	})
+	found := []int{}
+	offsets := []int64{}
+	for scanner.Scan() {
+		var doc struct{ N int }
+		if err := bson.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatal("Recovered an invalid document", err)
+		}
+		found = append(found, doc.N)
+		offsets = append(offsets, scanner.Offset())
+	}
+	if scanner.Err() != nil {
+		t.Fatal("Scanner error", scanner.Err())
+	}
+
+	secondOffset := int64(len(first) + len(garbage))
+	thirdOffset := secondOffset + int64(len(second)+len(third)-1)
+	if !reflect.DeepEqual(found, []int{1, 2, 3}) || !reflect.DeepEqual(offsets, []int64{0, secondOffset, thirdOffset}) {
+		t.Fatalf("Expected documents 1, 2 and 3 at 0, %d and %d, got %v at %v", secondOffset, thirdOffset, found, offsets)
+	}
+	expected := []Skip{
+		{Offset: int64(len(first)), Length: int64(len(garbage)), Reason: "size 2147483647 is more than the maximum of 16793600"},
+		{Offset: secondOffset + int64(len(second)), Length: int64(len(third) - 1)},
+		{Offset: thirdOffset + int64(len(third)), Length: 6, Reason: fmt.Sprintf("truncated: the size is %d but only 6 bytes are left", len(third))},
+	}
+	if len(skips) != len(expected) {
+		t.Fatalf("Expected %d skips, got %v", len(expected), skips)
+	}
+	for i := range expected {
+		if skips[i].Offset != expected[i].Offset || skips[i].Length != expected[i].Length {
+			t.Fatalf("Expected skip %v, got %v", expected[i], skips[i])
+		}
+		if expected[i].Reason != "" && skips[i].Reason != expected[i].Reason {
+			t.Fatalf("Expected skip reason %q, got %q", expected[i].Reason, skips[i].Reason)
+		}
+	}
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+// zeroes is an endless input of null bytes
+type zeroes struct{}
+
+func (zeroes) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestRecoveringDoesNotBufferGarbageSizes(t *testing.T) {
+	doc := marshal(t, bson.M{"n": 1})
+	// A size near the maximum followed by a type that doesn't exist, then plenty more input
+	garbage := []byte{0, 0, 0, 1, 0x42}
+	input := &countingReader{r: io.MultiReader(bytes.NewReader(garbage), bytes.NewReader(doc), zeroes{})}
+
+	skips := []Skip{}
+	scanner := NewRecovering(input, func(skip Skip) {
+		skips = append(skips, skip)
+	})
+	if !scanner.Scan() {
+		t.Fatal("Didn't recover the document", scanner.Err())
+	}
+	if !bytes.Equal(scanner.Bytes(), doc) || scanner.Offset() != int64(len(garbage)) {
+		t.Fatalf("Expected the document at %d, got %v at %d", len(garbage), scanner.Bytes(), scanner.Offset())
+	}
+	if input.read > 64*1024 {
+		t.Fatalf("Read %d bytes to skip %d bytes of garbage", input.read, len(garbage))
+	}
+	if len(skips) != 1 || skips[0].Length != int64(len(garbage)) || skips[0].Reason != "unknown type 0x42" {
+		t.Fatalf("Expected to skip the garbage, got %v", skips)
+	}
+}
+
+func TestUnchecked(t *testing.T) {
+	first := marshal(t, bson.M{"n": 1})
+	// Unchecked doesn't look inside documents, so an unknown type is returned as it is
+	badType := marshal(t, bson.M{"n": 2})
+	badType[4] = 0x42
+	input := bytes.Join([][]byte{first, badType, {0xff, 0xff, 0xff, 0x7f}}, nil)
+
+	scanner := NewUnchecked(bytes.NewReader(input))
+	for _, expected := range [][]byte{first, badType} {
+		if !scanner.Scan() {
+			t.Fatal("Didn't read a document", scanner.Err())
+		}
+		if !bytes.Equal(scanner.Bytes(), expected) {
+			t.Fatalf("Expected %v, got %v", expected, scanner.Bytes())
+		}
+	}
+	if scanner.Scan() {
+		t.Fatal("Read a document with an invalid size")
+	}
+	err, ok := scanner.Err().(*CorruptError)
+	if !ok {
+		t.Fatalf("Expected a CorruptError, got %v", scanner.Err())
+	}
+	if err.Offset != int64(len(first)+len(badType)) || err.Reason != "size 2147483647 is more than the maximum of 16793600" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
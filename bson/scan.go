@@ -40,6 +40,8 @@ type Scanner struct {
 	start        int       // First non-processed byte in buf.
 	end          int       // End of data in buf.
 	err          error     // Sticky error.
+	consumed     int64     // Number of bytes of input the split function has advanced past.
+	offset       int64     // Offset in the input of the data the last token was split from.
 }
 
 // SplitFunc is the signature of the split function used to tokenize the
@@ -101,6 +103,12 @@ func (s *Scanner) Bytes() []byte {
 	return s.token
 }
 
+// Offset returns the byte offset in the input of the data the most recent
+// token was split from. For BSON documents this is where the document starts.
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
 // Text returns the most recent token generated by a call to Scan
 // as a newly allocated string holding its bytes.
 func (s *Scanner) Text() string {
@@ -123,13 +131,19 @@ func (s *Scanner) Scan() bool {
 				s.setErr(err)
 				return false
 			}
+			tokenOffset := s.consumed
 			if !s.advance(advance) {
 				return false
 			}
 			s.token = token
 			if token != nil {
+				s.offset = tokenOffset
 				return true
 			}
+			// The split function skipped some input, so try again with what's left
+			if advance > 0 {
+				continue
+			}
 		}
 		// We cannot generate a token with what we are holding.
 		// If we've already hit EOF or an I/O error, we are done.
@@ -197,6 +211,7 @@ func (s *Scanner) advance(n int) bool {
 		return false
 	}
 	s.start += n
+	s.consumed += int64(n)
 	return true
 }
 
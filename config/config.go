@@ -0,0 +1,501 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Clever/mongo-op-throttler/apply"
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/input"
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/tail"
+	"github.com/Clever/mongo-op-throttler/target"
+	"github.com/Clever/mongo-op-throttler/transform"
+	"github.com/Clever/pathio"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes a replay job. It's read from YAML or JSON, since JSON is also valid YAML.
+type Config struct {
+	Target Target `yaml:"target"`
+	Input  Input  `yaml:"input"`
+	Rate   Rate   `yaml:"rate"`
+	// Filters limit which operations are applied
+	Filters Filters `yaml:"filters"`
+	// Remap renames namespaces, see transform.Remap
+	Remap map[string]string `yaml:"remap"`
+	// Transform is the path to a JavaScript transform script
+	Transform string `yaml:"transform"`
+	Errors    Errors `yaml:"errors"`
+	// DryRun validates the input without connecting to Mongo
+	DryRun           bool          `yaml:"dry_run"`
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	MetricsAddr      string        `yaml:"metrics_addr"`
+}
+
+// Target is the Mongo to apply operations to and how to connect to it
+type Target struct {
+	URL        string `yaml:"url"`
+	Driver     string `yaml:"driver"`
+	Connection `yaml:",inline"`
+
+	WriteConcern   string        `yaml:"write_concern"`
+	Journal        bool          `yaml:"journal"`
+	WTimeout       time.Duration `yaml:"wtimeout"`
+	ReadPreference string        `yaml:"read_preference"`
+}
+
+// Input is the oplog to replay
+type Input struct {
+	// Paths are merged and replayed in timestamp order. They can be directories or globs.
+	Paths []string `yaml:"paths"`
+	// MaxGap is the longest gap between one path's last entry and the next path's first entry
+	// before a warning is logged. Zero means gaps aren't checked, only overlaps.
+	MaxGap time.Duration `yaml:"max_gap"`
+	// Stream reads the paths directly instead of downloading them to a temporary file first
+	Stream bool `yaml:"stream"`
+	// Format is the encoding of the paths: auto, bson or extjson. auto reads paths ending in
+	// .json, .jsonl or .ndjson as Extended JSON lines and the rest as BSON.
+	Format string `yaml:"format"`
+	// FromTS starts the replay after this oplog ts, as seconds, seconds:increment or an RFC 3339
+	// time. Local uncompressed BSON files seek to it with an index instead of reading from the
+	// start, see the index subcommand.
+	FromTS string `yaml:"from_ts"`
+	// SaveIndex saves the indexes FromTS builds next to the files, so later replays can reuse them
+	SaveIndex bool `yaml:"save_index"`
+	// UntilTS stops the replay at the first entry after this ts
+	UntilTS string `yaml:"until_ts"`
+	// Recover skips over corrupt parts of the paths to the next valid entry instead of stopping
+	Recover bool `yaml:"recover"`
+	// Ops means the paths are ops files written by the convert subcommand instead of oplogs
+	Ops bool `yaml:"ops"`
+	// ArchiveNamespace is the namespace to replay from paths that are mongodump archives
+	ArchiveNamespace string `yaml:"archive_namespace"`
+	// Tail replays a replica set's oplog as it's written instead of reading paths
+	Tail Tail `yaml:"tail"`
+}
+
+// Connection is how to secure and authenticate a connection to Mongo
+type Connection struct {
+	TLS           bool   `yaml:"tls"`
+	CAFile        string `yaml:"tls_ca_file"`
+	CertFile      string `yaml:"tls_cert_file"`
+	KeyFile       string `yaml:"tls_key_file"`
+	AuthSource    string `yaml:"auth_source"`
+	AuthMechanism string `yaml:"auth_mechanism"`
+	Username      string `yaml:"username"`
+	Password      string `yaml:"password"`
+	PasswordFile  string `yaml:"password_file"`
+}
+
+// Tail is a replica set whose oplog is tailed
+type Tail struct {
+	// URL is the replica set member to read local.oplog.rs from, or with ChangeStream any
+	// member or a mongos
+	URL string `yaml:"url"`
+	// Connection is how to connect to the source. Unlike the target's, the credentials don't
+	// default to any environment variables.
+	Connection `yaml:",inline"`
+	// ChangeStream reads a change stream of every database instead of the oplog, which works
+	// on sharded clusters too. It needs Mongo 4.0 or later.
+	ChangeStream bool `yaml:"change_stream"`
+	// From is the ts to start after, as seconds, seconds:increment or an RFC 3339 time. By
+	// default tailing starts at the end of the oplog.
+	From string `yaml:"from"`
+	// Checkpoint is a local file the ts of the last replayed entry, or the resume token of the
+	// last change event, is saved in. If it exists tailing starts after it instead of From.
+	Checkpoint string `yaml:"checkpoint"`
+	// CheckpointInterval is how often the checkpoint is saved
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+}
+
+// Rate controls how fast operations are applied
+type Rate struct {
+	// OpsPerSecond of zero means there's no limit
+	OpsPerSecond float64 `yaml:"ops_per_second"`
+}
+
+// Filters limit which operations are applied. They match the namespaces operations are
+// written to, after any remapping and transform.
+type Filters struct {
+	// Namespaces, if set, are the only namespaces applied. They can be patterns like "clever.*".
+	Namespaces []string `yaml:"namespaces"`
+	// ExcludeNamespaces are never applied, even if they're in Namespaces
+	ExcludeNamespaces []string `yaml:"exclude_namespaces"`
+	// Types, if set, are the only operation types applied, out of insert, update and remove
+	Types []string `yaml:"types"`
+}
+
+// Errors is what to do when entries fail
+type Errors struct {
+	Continue   bool   `yaml:"continue"`
+	DeadLetter string `yaml:"dead_letter"`
+	MaxErrors  int    `yaml:"max_errors"`
+	MaxRetries int    `yaml:"max_retries"`
+}
+
+// Default returns the configuration used when there's no config file. Credentials default
+// to $MONGO_USERNAME and $MONGO_PASSWORD.
+func Default() Config {
+	return Config{
+		Target: Target{
+			URL:    "localhost",
+			Driver: "mgo",
+			Connection: Connection{
+				Username: os.Getenv("MONGO_USERNAME"),
+				Password: os.Getenv("MONGO_PASSWORD"),
+			},
+		},
+		Input: Input{
+			Format:           string(input.FormatAuto),
+			ArchiveNamespace: bsonScanner.ArchiveOplog,
+			Tail:             Tail{CheckpointInterval: tail.DefaultCheckpointInterval},
+		},
+		Rate:             Rate{OpsPerSecond: 1},
+		Errors:           Errors{MaxRetries: apply.DefaultRetryPolicy.MaxRetries},
+		ProgressInterval: apply.DefaultProgressInterval,
+	}
+}
+
+// Load reads a config file from a local path or any path pathio understands
+func Load(p string) (Config, error) {
+	reader, err := pathio.Reader(p)
+	if err != nil {
+		return Config{}, fmt.Errorf("Error opening config %s", err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return Config{}, fmt.Errorf("Error reading config %s", err)
+	}
+	return Parse(data)
+}
+
+// envVar matches an environment variable reference like ${MONGO_PASSWORD}
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Parse reads a YAML or JSON config on top of the defaults. Environment variables like
+// ${MONGO_PASSWORD} are expanded first. Only the braced form is expanded, so other $s, for
+// example in passwords, are left alone. Unknown fields are an error so typos aren't ignored.
+func Parse(data []byte) (Config, error) {
+	c := Default()
+	expanded := envVar.ReplaceAllStringFunc(string(data), func(ref string) string {
+		return os.Getenv(envVar.FindStringSubmatch(ref)[1])
+	})
+	if err := yaml.UnmarshalStrict([]byte(expanded), &c); err != nil {
+		return Config{}, fmt.Errorf("Error parsing config %s", err)
+	}
+	return c, nil
+}
+
+// Override sets the config field corresponding to a command line flag, so that flags take
+// precedence over the config file
+func (c *Config) Override(flag, value string) error {
+	var err error
+	switch flag {
+	case "mongoURL":
+		c.Target.URL = value
+	case "driver":
+		c.Target.Driver = value
+	case "tls":
+		c.Target.TLS, err = strconv.ParseBool(value)
+	case "tls-ca-file":
+		c.Target.CAFile = value
+	case "tls-cert-file":
+		c.Target.CertFile = value
+	case "tls-key-file":
+		c.Target.KeyFile = value
+	case "auth-source":
+		c.Target.AuthSource = value
+	case "auth-mechanism":
+		c.Target.AuthMechanism = value
+	case "username":
+		c.Target.Username = value
+	case "password-file":
+		c.Target.PasswordFile = value
+	case "write-concern":
+		c.Target.WriteConcern = value
+	case "journal":
+		c.Target.Journal, err = strconv.ParseBool(value)
+	case "wtimeout":
+		c.Target.WTimeout, err = time.ParseDuration(value)
+	case "read-preference":
+		c.Target.ReadPreference = value
+	case "path":
+		c.Input.Paths = strings.Split(value, ",")
+	case "max-gap":
+		c.Input.MaxGap, err = time.ParseDuration(value)
+	case "stream":
+		c.Input.Stream, err = strconv.ParseBool(value)
+	case "tail":
+		c.Input.Tail.URL = value
+	case "change-stream":
+		c.Input.Tail.ChangeStream, err = strconv.ParseBool(value)
+	case "tail-tls":
+		c.Input.Tail.TLS, err = strconv.ParseBool(value)
+	case "tail-tls-ca-file":
+		c.Input.Tail.CAFile = value
+	case "tail-tls-cert-file":
+		c.Input.Tail.CertFile = value
+	case "tail-tls-key-file":
+		c.Input.Tail.KeyFile = value
+	case "tail-auth-source":
+		c.Input.Tail.AuthSource = value
+	case "tail-auth-mechanism":
+		c.Input.Tail.AuthMechanism = value
+	case "tail-username":
+		c.Input.Tail.Username = value
+	case "tail-password-file":
+		c.Input.Tail.PasswordFile = value
+	case "tail-from":
+		c.Input.Tail.From = value
+	case "checkpoint":
+		c.Input.Tail.Checkpoint = value
+	case "checkpoint-interval":
+		c.Input.Tail.CheckpointInterval, err = time.ParseDuration(value)
+	case "from-ts":
+		c.Input.FromTS = value
+	case "until-ts":
+		c.Input.UntilTS = value
+	case "save-index":
+		c.Input.SaveIndex, err = strconv.ParseBool(value)
+	case "recover":
+		c.Input.Recover, err = strconv.ParseBool(value)
+	case "ops":
+		c.Input.Ops, err = strconv.ParseBool(value)
+	case "input-format":
+		c.Input.Format = value
+	case "archive-namespace":
+		c.Input.ArchiveNamespace = value
+	case "speed":
+		c.Rate.OpsPerSecond, err = strconv.ParseFloat(value, 64)
+	case "transform":
+		c.Transform = value
+	case "continue-on-error":
+		c.Errors.Continue, err = strconv.ParseBool(value)
+	case "dead-letter":
+		c.Errors.DeadLetter = value
+	case "max-errors":
+		c.Errors.MaxErrors, err = strconv.Atoi(value)
+	case "max-retries":
+		c.Errors.MaxRetries, err = strconv.Atoi(value)
+	case "dry-run":
+		c.DryRun, err = strconv.ParseBool(value)
+	case "progress-interval":
+		c.ProgressInterval, err = time.ParseDuration(value)
+	case "metrics-addr":
+		c.MetricsAddr = value
+	default:
+		return fmt.Errorf("Unknown flag %s", flag)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %s for --%s", value, flag)
+	}
+	return nil
+}
+
+// Validate checks the whole config and reports every problem it finds, not just the first
+func (c Config) Validate() error {
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Target.URL == "" {
+		add("target.url is required")
+	}
+	if c.Target.Driver != "mgo" && c.Target.Driver != "mongo-driver" {
+		add("target.driver must be mgo or mongo-driver, not %q", c.Target.Driver)
+	}
+	if (c.Target.CertFile == "") != (c.Target.KeyFile == "") {
+		add("target.tls_cert_file and target.tls_key_file must be set together")
+	}
+	if err := c.SessionOptions().Validate(); err != nil {
+		add("target: %s", err)
+	}
+
+	if c.Input.Tail.URL != "" {
+		if len(c.Input.Paths) > 0 {
+			add("input.paths and input.tail.url can't both be set")
+		}
+		if c.Input.Tail.From != "" {
+			if _, err := tail.ParseTimestamp(c.Input.Tail.From); err != nil {
+				add("input.tail.from: %s", err)
+			}
+		}
+		if (c.Input.Tail.CertFile == "") != (c.Input.Tail.KeyFile == "") {
+			add("input.tail.tls_cert_file and input.tail.tls_key_file must be set together")
+		}
+		if c.Input.Tail.CheckpointInterval < 0 {
+			add("input.tail.checkpoint_interval can't be negative")
+		}
+		if c.DryRun {
+			add("dry_run can't be used with input.tail.url, since a tailed oplog never ends")
+		}
+		if c.Input.Ops {
+			add("input.ops can't be used with input.tail.url")
+		}
+	} else if len(c.Input.Paths) == 0 {
+		add("input.paths needs at least one path")
+	} else if c.Input.Tail.From != "" || c.Input.Tail.Checkpoint != "" || c.Input.Tail.ChangeStream {
+		add("input.tail.from, input.tail.checkpoint and input.tail.change_stream need input.tail.url")
+	}
+	for i, p := range c.Input.Paths {
+		if p == "" {
+			add("input.paths[%d] is empty", i)
+		}
+	}
+
+	if _, err := tail.ParseTimestamp(c.Input.FromTS); c.Input.FromTS != "" && err != nil {
+		add("input.from_ts: %s", err)
+	}
+	if _, err := tail.ParseTimestamp(c.Input.UntilTS); c.Input.UntilTS != "" && err != nil {
+		add("input.until_ts: %s", err)
+	}
+	if c.Input.Tail.URL != "" && (c.Input.FromTS != "" || c.Input.UntilTS != "") {
+		add("input.from_ts and input.until_ts can't be used with input.tail.url, use input.tail.from")
+	}
+	if c.Input.MaxGap < 0 {
+		add("input.max_gap can't be negative")
+	}
+	if _, err := input.ParseFormat(c.Input.Format); err != nil {
+		add("input.format: %s", err)
+	}
+	if c.Input.ArchiveNamespace == "" {
+		add("input.archive_namespace can't be empty")
+	}
+
+	if c.Rate.OpsPerSecond < 0 {
+		add("rate.ops_per_second can't be negative")
+	}
+
+	for _, patterns := range [][]string{c.Filters.Namespaces, c.Filters.ExcludeNamespaces} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add("filters: invalid namespace pattern %q", pattern)
+			}
+		}
+	}
+	for _, t := range c.Filters.Types {
+		if t != "insert" && t != "update" && t != "remove" {
+			add("filters.types: unknown type %q, expected insert, update or remove", t)
+		}
+	}
+
+	for from, to := range c.Remap {
+		fromNamespace, toNamespace := strings.Contains(from, "."), strings.Contains(to, ".")
+		if from == "" || to == "" || fromNamespace != toNamespace {
+			add("remap: %q to %q must map a database to a database or a namespace to a namespace", from, to)
+		}
+	}
+
+	if c.Errors.MaxErrors < 0 {
+		add("errors.max_errors can't be negative")
+	}
+	if c.Errors.MaxRetries < 0 {
+		add("errors.max_retries can't be negative")
+	}
+	if !c.Errors.Continue && (c.Errors.DeadLetter != "" || c.Errors.MaxErrors != 0) {
+		add("errors.dead_letter and errors.max_errors need errors.continue")
+	}
+
+	if c.ProgressInterval <= 0 {
+		add("progress_interval must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// DialOptions returns how to connect to the target. It reads the password file if there is one.
+func (c Config) DialOptions() (target.DialOptions, error) {
+	return c.Target.Connection.dialOptions(c.Target.URL)
+}
+
+// DialOptions returns how to connect to the source. It reads the password file if there is one.
+func (t Tail) DialOptions() (target.DialOptions, error) {
+	return t.Connection.dialOptions(t.URL)
+}
+
+func (c Connection) dialOptions(url string) (target.DialOptions, error) {
+	opts := target.DialOptions{
+		URL:           url,
+		TLS:           c.TLS,
+		CAFile:        c.CAFile,
+		CertFile:      c.CertFile,
+		KeyFile:       c.KeyFile,
+		AuthSource:    c.AuthSource,
+		AuthMechanism: c.AuthMechanism,
+		Username:      c.Username,
+		Password:      c.Password,
+	}
+	if c.PasswordFile != "" {
+		password, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return opts, fmt.Errorf("Error reading password file %s", err)
+		}
+		opts.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	return opts, nil
+}
+
+// SessionOptions returns the write concern and read preference to use
+func (c Config) SessionOptions() target.SessionOptions {
+	return target.SessionOptions{
+		W:              c.Target.WriteConcern,
+		J:              c.Target.Journal,
+		WTimeout:       c.Target.WTimeout,
+		ReadPreference: c.Target.ReadPreference,
+	}
+}
+
+// ApplyFilters returns the filters to pass to apply.Run
+func (c Config) ApplyFilters() []apply.Filter {
+	filters := []apply.Filter{}
+	if len(c.Filters.Namespaces) > 0 {
+		filters = append(filters, func(op operation.Op) bool {
+			return matchesAny(op.Namespace, c.Filters.Namespaces)
+		})
+	}
+	if len(c.Filters.ExcludeNamespaces) > 0 {
+		filters = append(filters, func(op operation.Op) bool {
+			return !matchesAny(op.Namespace, c.Filters.ExcludeNamespaces)
+		})
+	}
+	if len(c.Filters.Types) > 0 {
+		filters = append(filters, func(op operation.Op) bool {
+			for _, t := range c.Filters.Types {
+				if op.Type == t {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return filters
+}
+
+func matchesAny(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Remapper returns a transformer for the remap section, or nil if it's empty
+func (c Config) Remapper() transform.Transformer {
+	if len(c.Remap) == 0 {
+		return nil
+	}
+	return transform.Remap(c.Remap)
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/target"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseYAML(t *testing.T) {
+	os.Setenv("THROTTLER_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("THROTTLER_TEST_PASSWORD")
+
+	c, err := Parse([]byte(`
+target:
+  url: mongodb://db.example.com
+  driver: mongo-driver
+  username: replayer
+  password: ${THROTTLER_TEST_PASSWORD}
+  write_concern: majority
+  wtimeout: 10s
+input:
+  paths: [s3://bucket/oplog1.bson, s3://bucket/oplog2.bson]
+rate:
+  ops_per_second: 500
+filters:
+  namespaces: ["clever.*"]
+  exclude_namespaces: [clever.events]
+  types: [insert, update]
+remap:
+  clever: clever_copy
+errors:
+  continue: true
+  dead_letter: failed.jsonl
+progress_interval: 1m
+`))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, "mongodb://db.example.com", c.Target.URL)
+	assert.Equal(t, "mongo-driver", c.Target.Driver)
+	assert.Equal(t, "secret", c.Target.Password)
+	assert.Equal(t, 10*time.Second, c.Target.WTimeout)
+	assert.Equal(t, []string{"s3://bucket/oplog1.bson", "s3://bucket/oplog2.bson"}, c.Input.Paths)
+	assert.Equal(t, 500.0, c.Rate.OpsPerSecond)
+	assert.Equal(t, map[string]string{"clever": "clever_copy"}, c.Remap)
+	assert.Equal(t, time.Minute, c.ProgressInterval)
+	// Defaults are kept for anything the file doesn't set
+	assert.Equal(t, 8, c.Errors.MaxRetries)
+}
+
+func TestParseKeepsDollarSigns(t *testing.T) {
+	os.Setenv("THROTTLER_TEST_USERNAME", "replayer")
+	defer os.Unsetenv("THROTTLER_TEST_USERNAME")
+
+	c, err := Parse([]byte(`
+target:
+  username: ${THROTTLER_TEST_USERNAME}
+  password: pa$word$HOME$
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "replayer", c.Target.Username)
+	assert.Equal(t, "pa$word$HOME$", c.Target.Password)
+}
+
+func TestParseJSON(t *testing.T) {
+	c, err := Parse([]byte(`{"target": {"url": "localhost"}, "input": {"paths": ["oplog.bson"]}, "rate": {"ops_per_second": 0}}`))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, 0.0, c.Rate.OpsPerSecond)
+	assert.Equal(t, "mgo", c.Target.Driver)
+}
+
+func TestParseUnknownField(t *testing.T) {
+	_, err := Parse([]byte("rate:\n  ops_per_secnd: 5\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "field ops_per_secnd not found")
+}
+
+func TestOverride(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Override("path", "oplog.bson,oplogs/"))
+	assert.NoError(t, c.Override("max-gap", "1h"))
+	assert.NoError(t, c.Override("speed", "250"))
+	assert.NoError(t, c.Override("journal", "true"))
+	assert.NoError(t, c.Override("stream", "true"))
+	assert.NoError(t, c.Override("archive-namespace", "clever.sections"))
+	assert.NoError(t, c.Override("input-format", "extjson"))
+	assert.NoError(t, c.Override("ops", "true"))
+	assert.NoError(t, c.Override("recover", "true"))
+	assert.NoError(t, c.Override("save-index", "true"))
+	assert.NoError(t, c.Override("wtimeout", "5s"))
+	assert.Equal(t, []string{"oplog.bson", "oplogs/"}, c.Input.Paths)
+	assert.Equal(t, time.Hour, c.Input.MaxGap)
+	assert.Equal(t, 250.0, c.Rate.OpsPerSecond)
+	assert.True(t, c.Target.Journal)
+	assert.True(t, c.Input.Stream)
+	assert.Equal(t, "clever.sections", c.Input.ArchiveNamespace)
+	assert.Equal(t, "extjson", c.Input.Format)
+	assert.True(t, c.Input.Ops)
+	assert.True(t, c.Input.Recover)
+	assert.True(t, c.Input.SaveIndex)
+	assert.Equal(t, 5*time.Second, c.Target.WTimeout)
+
+	err := c.Override("speed", "fast")
+	assert.Error(t, err)
+	assert.Equal(t, "Invalid value fast for --speed", err.Error())
+	assert.Error(t, c.Override("config", "other.yml"))
+}
+
+func TestValidateTail(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Override("tail", "mongodb://source.example.com"))
+	assert.NoError(t, c.Override("tail-from", "1500000000:3"))
+	assert.NoError(t, c.Override("checkpoint", "checkpoint.txt"))
+	assert.NoError(t, c.Override("checkpoint-interval", "1m"))
+	assert.NoError(t, c.Override("change-stream", "true"))
+	assert.NoError(t, c.Validate())
+	assert.True(t, c.Input.Tail.ChangeStream)
+	assert.Equal(t, time.Minute, c.Input.Tail.CheckpointInterval)
+
+	c.Input.Paths = []string{"oplog.bson"}
+	c.Input.Tail.From = "yesterday"
+	c.DryRun = true
+	err := c.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, `Invalid config:
+  input.paths and input.tail.url can't both be set
+  input.tail.from: Invalid timestamp "yesterday", expected seconds, seconds:increment or an RFC 3339 time
+  dry_run can't be used with input.tail.url, since a tailed oplog never ends`, err.Error())
+
+	c = Default()
+	c.Input.Paths = []string{"oplog.bson"}
+	c.Input.Tail.Checkpoint = "checkpoint.txt"
+	assert.Error(t, c.Validate())
+}
+
+func TestTailDialOptions(t *testing.T) {
+	f, err := ioutil.TempFile("", "password")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("source-secret\n")
+	assert.NoError(t, err)
+	f.Close()
+
+	c, err := Parse([]byte(`
+input:
+  tail:
+    url: mongodb://source.example.com
+    tls_ca_file: ca.pem
+    auth_source: admin
+    username: tailer
+`))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Override("tail-password-file", f.Name()))
+	assert.NoError(t, c.Override("tail-auth-mechanism", "SCRAM-SHA-256"))
+	assert.NoError(t, c.Validate())
+
+	opts, err := c.Input.Tail.DialOptions()
+	assert.NoError(t, err)
+	assert.Equal(t, target.DialOptions{
+		URL:           "mongodb://source.example.com",
+		CAFile:        "ca.pem",
+		AuthSource:    "admin",
+		AuthMechanism: "SCRAM-SHA-256",
+		Username:      "tailer",
+		Password:      "source-secret",
+	}, opts)
+
+	c.Input.Tail.CertFile = "cert.pem"
+	assert.Error(t, c.Validate())
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Target.Driver = "mongoose"
+	c.Target.CertFile = "cert.pem"
+	c.Rate.OpsPerSecond = -1
+	c.Input.Format = "csv"
+	c.Input.UntilTS = "soon"
+	c.Filters.Types = []string{"upsert"}
+	c.Filters.Namespaces = []string{"clever.[events"}
+	c.Remap = map[string]string{"clever": "clever_copy.sections"}
+	c.Errors.DeadLetter = "failed.bson"
+	err := c.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, `Invalid config:
+  target.driver must be mgo or mongo-driver, not "mongoose"
+  target.tls_cert_file and target.tls_key_file must be set together
+  input.paths needs at least one path
+  input.until_ts: Invalid timestamp "soon", expected seconds, seconds:increment or an RFC 3339 time
+  input.format: Unknown input format "csv", it must be auto, bson or extjson
+  rate.ops_per_second can't be negative
+  filters: invalid namespace pattern "clever.[events"
+  filters.types: unknown type "upsert", expected insert, update or remove
+  remap: "clever" to "clever_copy.sections" must map a database to a database or a namespace to a namespace
+  errors.dead_letter and errors.max_errors need errors.continue`, err.Error())
+}
+
+func TestApplyFilters(t *testing.T) {
+	c := Default()
+	c.Filters = Filters{
+		Namespaces:        []string{"clever.*", "other.sections"},
+		ExcludeNamespaces: []string{"clever.events"},
+		Types:             []string{"insert"},
+	}
+	passes := func(op operation.Op) bool {
+		for _, filter := range c.ApplyFilters() {
+			if !filter(op) {
+				return false
+			}
+		}
+		return true
+	}
+	assert.True(t, passes(operation.Op{Namespace: "clever.sections", Type: "insert"}))
+	assert.True(t, passes(operation.Op{Namespace: "other.sections", Type: "insert"}))
+	assert.False(t, passes(operation.Op{Namespace: "other.events", Type: "insert"}))
+	assert.False(t, passes(operation.Op{Namespace: "clever.events", Type: "insert"}))
+	assert.False(t, passes(operation.Op{Namespace: "clever.sections", Type: "remove"}))
+
+	assert.Equal(t, 0, len(Default().ApplyFilters()))
+}
@@ -0,0 +1,86 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// changeEvent is the part of a change stream event needed to convert it to an op. See
+// https://docs.mongodb.com/manual/reference/change-events/
+type changeEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   bson.MongoTimestamp `bson:"clusterTime"`
+	Namespace     struct {
+		Database   string `bson:"db"`
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.M `bson:"documentKey"`
+	FullDocument      bson.M `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields   bson.M        `bson:"updatedFields"`
+		RemovedFields   []string      `bson:"removedFields"`
+		TruncatedArrays []interface{} `bson:"truncatedArrays"`
+	} `bson:"updateDescription"`
+}
+
+// ChangeEventBytesToOp converts the raw bytes of a change stream event into an operation, the
+// way OplogBytesToOp does for oplog entries. Inserts and replaces become inserts of the full
+// document, which are applied as upserts, and updates become $set and $unset updates. Events
+// that don't change a document, like drops and renames, are errors, like commands in an oplog.
+func ChangeEventBytesToOp(raw []byte) (*operation.Op, error) {
+	var event changeEvent
+	if err := bson.Unmarshal(raw, &event); err != nil {
+		return nil, fmt.Errorf("Error parsing bson: %s", err.Error())
+	}
+	if event.Namespace.Database == "" || event.Namespace.Collection == "" {
+		return nil, fmt.Errorf("Unsupported change event type %s", event.OperationType)
+	}
+	op := operation.Op{
+		Namespace: event.Namespace.Database + "." + event.Namespace.Collection,
+		Timestamp: event.ClusterTime,
+	}
+	id, ok := event.DocumentKey["_id"]
+	if !ok {
+		return nil, fmt.Errorf("Change event missing documentKey._id %#v", event)
+	}
+	var err error
+	if op.ID, err = convertIdToString(id); err != nil {
+		return nil, err
+	}
+
+	switch event.OperationType {
+	case "insert", "replace":
+		if event.FullDocument == nil {
+			return nil, fmt.Errorf("%s change event missing fullDocument for %s", event.OperationType, op.ID)
+		}
+		op.Type = "insert"
+		op.Obj = event.FullDocument
+	case "update":
+		if len(event.UpdateDescription.TruncatedArrays) > 0 {
+			return nil, fmt.Errorf("Update of %s truncates arrays, which can't be replayed with $set and $unset", op.ID)
+		}
+		op.Type = "update"
+		op.Obj = bson.M{}
+		if len(event.UpdateDescription.UpdatedFields) > 0 {
+			op.Obj["$set"] = event.UpdateDescription.UpdatedFields
+		}
+		if len(event.UpdateDescription.RemovedFields) > 0 {
+			unset := bson.M{}
+			for _, field := range event.UpdateDescription.RemovedFields {
+				unset[field] = 1
+			}
+			op.Obj["$unset"] = unset
+		}
+		// An update without any changes would replace the document with an empty one
+		if len(op.Obj) == 0 {
+			return nil, nil
+		}
+	case "delete":
+		op.Type = "remove"
+	default:
+		return nil, fmt.Errorf("Unsupported change event type %s", event.OperationType)
+	}
+	return &op, nil
+}
@@ -0,0 +1,75 @@
+package convert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func changeEventBytes(t *testing.T, event bson.M) []byte {
+	event["_id"] = bson.M{"_data": "8262"}
+	event["ns"] = bson.M{"db": "clever", "coll": "schools"}
+	event["clusterTime"] = bson.MongoTimestamp(1500000000<<32 | 2)
+	raw, err := bson.Marshal(event)
+	assert.NoError(t, err)
+	return raw
+}
+
+func TestChangeEventToOp(t *testing.T) {
+	id := bson.NewObjectId()
+	doc := bson.M{"_id": id, "name": "school"}
+
+	for _, operationType := range []string{"insert", "replace"} {
+		op, err := ChangeEventBytesToOp(changeEventBytes(t, bson.M{
+			"operationType": operationType, "documentKey": bson.M{"_id": id}, "fullDocument": doc,
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, "insert", op.Type)
+		assert.Equal(t, "clever.schools", op.Namespace)
+		assert.Equal(t, id.Hex(), op.ID)
+		assert.Equal(t, doc, op.Obj)
+		assert.Equal(t, bson.MongoTimestamp(1500000000<<32|2), op.Timestamp)
+	}
+
+	op, err := ChangeEventBytesToOp(changeEventBytes(t, bson.M{
+		"operationType": "update", "documentKey": bson.M{"_id": id, "district": "d1"},
+		"updateDescription": bson.M{"updatedFields": bson.M{"name": "new", "a.b": 1}, "removedFields": []string{"old"}, "truncatedArrays": []interface{}{}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "update", op.Type)
+	assert.Equal(t, bson.M{"$set": bson.M{"name": "new", "a.b": 1}, "$unset": bson.M{"old": 1}}, op.Obj)
+
+	op, err = ChangeEventBytesToOp(changeEventBytes(t, bson.M{"operationType": "delete", "documentKey": bson.M{"_id": "stringId"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, "remove", op.Type)
+	assert.Equal(t, "stringId", op.ID)
+
+	// An update that doesn't change anything is a no-op
+	op, err = ChangeEventBytesToOp(changeEventBytes(t, bson.M{
+		"operationType": "update", "documentKey": bson.M{"_id": id}, "updateDescription": bson.M{"updatedFields": bson.M{}},
+	}))
+	assert.NoError(t, err)
+	assert.Nil(t, op)
+}
+
+func TestChangeEventToOpErrors(t *testing.T) {
+	id := bson.NewObjectId()
+	for _, event := range []bson.M{
+		{"operationType": "drop"},
+		{"operationType": "rename", "documentKey": bson.M{"_id": id}},
+		{"operationType": "insert", "documentKey": bson.M{"_id": id}},
+		{"operationType": "insert", "fullDocument": bson.M{"_id": id}},
+		{"operationType": "update", "documentKey": bson.M{"_id": id},
+			"updateDescription": bson.M{"updatedFields": bson.M{}, "truncatedArrays": []bson.M{{"field": "list", "newSize": 1}}}},
+	} {
+		_, err := ChangeEventBytesToOp(changeEventBytes(t, event))
+		assert.Error(t, err, "%v", event)
+	}
+
+	raw, err := bson.Marshal(bson.M{"operationType": "dropDatabase", "ns": bson.M{"db": "clever"}})
+	assert.NoError(t, err)
+	_, err = ChangeEventBytesToOp(raw)
+	assert.Error(t, err)
+	assert.Equal(t, "Unsupported change event type dropDatabase", err.Error())
+}
@@ -13,7 +13,7 @@ import (
 // the oplog entries to the database.
 // 1. Keeps the logic for understanding oplogs separate from the rest of the code
 // 2. Makes it easier to have a worker that takes in a file of operation.Ops instead
-// of the oplog
+// of the oplog. WriteOps makes those files, and operation.Unmarshal reads them.
 func OplogBytesToOp(raw []byte) (*operation.Op, error) {
 	var bsonOp bson.M
 	if err := bson.Unmarshal(raw, &bsonOp); err != nil {
@@ -63,6 +63,11 @@ func oplogEntryToOp(oplogEntry bson.M) (*operation.Op, error) {
 	if !ok {
 		return nil, fmt.Errorf("Missing op type %#v\n", oplogEntry)
 	}
+	// Replica sets write no-ops, like the periodic ones every ten seconds when they're idle,
+	// which have nothing to replay
+	if opType == "n" {
+		return nil, nil
+	}
 	namespace, ok := oplogEntry["ns"].(string)
 	if !ok {
 		return nil, fmt.Errorf("Missing namespace %#v\n", oplogEntry)
@@ -78,18 +83,25 @@ func oplogEntryToOp(oplogEntry bson.M) (*operation.Op, error) {
 		return nil, fmt.Errorf("Missing object field %#v\n", oplogEntry)
 	}
 
+	var op *operation.Op
+	var err error
 	switch opType {
 	case "i":
-		return convertToInsert(namespace, obj)
+		op, err = convertToInsert(namespace, obj)
 	case "u":
-		return convertToUpdate(namespace, obj, oplogEntry)
+		op, err = convertToUpdate(namespace, obj, oplogEntry)
 	case "d":
-		return convertToRemove(namespace, obj, oplogEntry)
+		op, err = convertToRemove(namespace, obj, oplogEntry)
 	default:
-		// It's theoretically possibly that is also 'c', 'n', or 'db', but we don't support them so
+		// It's theoretically possibly that is also 'c' or 'db', but we don't support them so
 		// let's error out.
 		return nil, fmt.Errorf("Unknown op type %s", opType)
 	}
+	if op != nil {
+		// Not every source of oplog entries (tests, for example) sets a timestamp
+		op.Timestamp, _ = oplogEntry["ts"].(bson.MongoTimestamp)
+	}
+	return op, err
 }
 
 func convertToInsert(namespace string, obj bson.M) (*operation.Op, error) {
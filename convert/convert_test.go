@@ -26,6 +26,23 @@ func TestConvertBsonBytes(t *testing.T) {
 	assert.Equal(t, "insert", op.Type)
 }
 
+func TestConvertKeepsTimestamp(t *testing.T) {
+	doc := bson.M{
+		"ts": bson.MongoTimestamp(6021954253944258561),
+		"op": "d",
+		"v":  2,
+		"ns": "throttle.test",
+		"b":  true,
+		"o":  bson.M{"_id": "id"},
+	}
+	bytes, err := bson.Marshal(doc)
+	assert.NoError(t, err)
+
+	op, err := OplogBytesToOp(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.MongoTimestamp(6021954253944258561), op.Timestamp)
+}
+
 func TestConvertInsertOp(t *testing.T) {
 	obj := bson.M{
 		"_id": "teacherId",
@@ -103,6 +120,22 @@ func TestUnknownOp(t *testing.T) {
 	assert.Equal(t, "Unknown op type c", err.Error())
 }
 
+func TestNoOp(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1500000000 << 32),
+		"h":  int64(0),
+		"v":  2,
+		"op": "n",
+		"ns": "",
+		"o":  bson.M{"msg": "periodic noop"},
+	})
+	assert.NoError(t, err)
+
+	op, err := OplogBytesToOp(raw)
+	assert.NoError(t, err)
+	assert.Nil(t, op)
+}
+
 func TestInvalidUpdateOperation(t *testing.T) {
 	doc := bson.M{
 		"v":  2,
@@ -0,0 +1,47 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+
+	// Use custom scanner with higher length limitation
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/operation"
+)
+
+// OpsSummary counts what WriteOps did
+type OpsSummary struct {
+	// Entries is the number of oplog entries read
+	Entries int
+	// Ops is the number of ops written
+	Ops int
+	// NoOps is the number of entries that don't result in an op, like index creations
+	NoOps int
+}
+
+// WriteOps converts every entry of a BSON oplog into an op and writes it to w, making an ops
+// file that can be replayed without converting the oplog again. It stops at the first entry
+// that can't be converted.
+func WriteOps(r io.Reader, w *operation.Writer) (OpsSummary, error) {
+	summary := OpsSummary{}
+	opScanner := bsonScanner.NewUnchecked(r)
+	for opScanner.Scan() {
+		summary.Entries++
+		op, err := OplogBytesToOp(opScanner.Bytes())
+		if err != nil {
+			return summary, fmt.Errorf("Error converting oplog entry at offset %d %s", opScanner.Offset(), err)
+		}
+		if op == nil {
+			summary.NoOps++
+			continue
+		}
+		if err := w.Write(*op); err != nil {
+			return summary, err
+		}
+		summary.Ops++
+	}
+	if err := opScanner.Err(); err != nil {
+		return summary, fmt.Errorf("Error reading oplog %s", err)
+	}
+	return summary, nil
+}
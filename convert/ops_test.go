@@ -0,0 +1,49 @@
+package convert
+
+import (
+	"bytes"
+	"testing"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWriteOps(t *testing.T) {
+	oplog := &bytes.Buffer{}
+	id := bson.NewObjectId()
+	for _, entry := range []bson.M{
+		{"v": 2, "op": "i", "ns": "throttle.test", "o": bson.M{"_id": id, "key": "value"}},
+		{"v": 2, "op": "i", "ns": "throttle.system.indexes", "o": bson.M{"key": bson.M{"val": 1}}},
+		{"v": 2, "op": "d", "ns": "throttle.test", "b": true, "o": bson.M{"_id": id}},
+	} {
+		raw, err := bson.Marshal(entry)
+		assert.NoError(t, err)
+		oplog.Write(raw)
+	}
+
+	ops := &bytes.Buffer{}
+	summary, err := WriteOps(oplog, operation.NewWriter(ops, operation.BSON))
+	assert.NoError(t, err)
+	assert.Equal(t, OpsSummary{Entries: 3, Ops: 2, NoOps: 1}, summary)
+
+	scanner := bsonScanner.New(ops)
+	types := []string{}
+	for scanner.Scan() {
+		op, err := operation.Unmarshal(scanner.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, id.Hex(), op.ID)
+		types = append(types, op.Type)
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"insert", "remove"}, types)
+}
+
+func TestWriteOpsInvalidEntry(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{"v": 2, "op": "c", "ns": "throttle.$cmd", "o": bson.M{"create": "test"}})
+	assert.NoError(t, err)
+	_, err = WriteOps(bytes.NewReader(raw), operation.NewWriter(&bytes.Buffer{}, operation.BSON))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "offset 0")
+}
@@ -0,0 +1,101 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Stages at which an entry can fail
+const (
+	StageConvert   = "convert"
+	StageTransform = "transform"
+	StageApply     = "apply"
+)
+
+// Entry records an oplog entry that couldn't be replayed
+type Entry struct {
+	// Offset is where the entry starts in the input, in bytes
+	Offset int64 `bson:"offset" json:"offset"`
+	// Namespace is the entry's namespace, if it could be read
+	Namespace string `bson:"ns" json:"ns"`
+	// Stage is where the entry failed, one of the Stage constants
+	Stage string `bson:"stage" json:"stage"`
+	// Error is the error message
+	Error string `bson:"error" json:"error"`
+	// Raw is the original BSON of the entry. It's embedded as a document in the BSON format
+	// and base64 encoded in the JSON format.
+	Raw []byte `bson:"-" json:"entry"`
+}
+
+// Format is the file format of a dead letter file
+type Format string
+
+const (
+	// BSON writes each entry as a BSON document with the original oplog entry embedded in the
+	// "entry" field, so the file can be read with bsondump or this repo's bson scanner
+	BSON Format = "bson"
+	// JSON writes each entry as a line of JSON
+	JSON Format = "json"
+)
+
+// FormatFromPath guesses the format of a dead letter file from its extension, defaulting to BSON
+func FormatFromPath(path string) Format {
+	if strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".jsonl") {
+		return JSON
+	}
+	return BSON
+}
+
+// Writer writes dead letter entries. It's safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// NewWriter returns a Writer that writes entries to w in the given format
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// Write records a single entry
+func (w *Writer) Write(e Entry) error {
+	var out []byte
+	var err error
+	switch w.format {
+	case JSON:
+		if out, err = json.Marshal(e); err == nil {
+			out = append(out, '\n')
+		}
+	case BSON:
+		var entry interface{} = bson.Raw{Kind: 0x03, Data: e.Raw}
+		if bson.Unmarshal(e.Raw, &bson.D{}) != nil {
+			// Embedding a corrupt document would make the whole file unreadable, so keep it as binary
+			entry = e.Raw
+		}
+		out, err = bson.Marshal(bson.D{
+			{Name: "offset", Value: e.Offset},
+			{Name: "ns", Value: e.Namespace},
+			{Name: "stage", Value: e.Stage},
+			{Name: "error", Value: e.Error},
+			{Name: "entry", Value: entry},
+		})
+	default:
+		return fmt.Errorf("Unknown dead letter format %s", w.format)
+	}
+	if err != nil {
+		return fmt.Errorf("Error encoding dead letter entry %s", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(out); err != nil {
+		return fmt.Errorf("Error writing dead letter entry %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package deadletter
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func testEntry(t *testing.T) Entry {
+	raw, err := bson.Marshal(bson.M{"v": 2, "op": "c", "ns": "throttle.$cmd", "o": bson.M{"create": "test"}})
+	assert.NoError(t, err)
+	return Entry{Offset: 42, Namespace: "throttle.$cmd", Stage: StageConvert, Error: "Unknown op type c", Raw: raw}
+}
+
+func TestWriteBSON(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	w := NewWriter(buffer, BSON)
+	entry := testEntry(t)
+	assert.NoError(t, w.Write(entry))
+	assert.NoError(t, w.Write(entry))
+
+	// The file can be read back with the same scanner we use for oplogs
+	scanner := bsonScanner.New(buffer)
+	numEntries := 0
+	for scanner.Scan() {
+		var doc struct {
+			Offset int64
+			NS     string
+			Stage  string
+			Error  string
+			Entry  bson.M
+		}
+		assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &doc))
+		assert.Equal(t, int64(42), doc.Offset)
+		assert.Equal(t, "throttle.$cmd", doc.NS)
+		assert.Equal(t, StageConvert, doc.Stage)
+		assert.Equal(t, "Unknown op type c", doc.Error)
+		assert.Equal(t, "c", doc.Entry["op"])
+		numEntries++
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, 2, numEntries)
+}
+
+func TestWriteBSONCorruptEntry(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	w := NewWriter(buffer, BSON)
+	entry := testEntry(t)
+	entry.Raw = []byte{0x10, 0x00, 0x00, 0x00, 0xff}
+	assert.NoError(t, w.Write(entry))
+
+	var doc bson.M
+	assert.NoError(t, bson.Unmarshal(buffer.Bytes(), &doc))
+	assert.Equal(t, entry.Raw, doc["entry"])
+}
+
+func TestWriteJSON(t *testing.T) {
+	buffer := bytes.NewBufferString("")
+	w := NewWriter(buffer, JSON)
+	entry := testEntry(t)
+	assert.NoError(t, w.Write(entry))
+
+	var decoded Entry
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, entry, decoded)
+}
+
+func TestFormatFromPath(t *testing.T) {
+	assert.Equal(t, JSON, FormatFromPath("/tmp/failed.json"))
+	assert.Equal(t, JSON, FormatFromPath("/tmp/failed.jsonl"))
+	assert.Equal(t, BSON, FormatFromPath("/tmp/failed.bson"))
+}
@@ -0,0 +1,219 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	// Use custom scanner with higher length limitation
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultInterval is how many bytes of oplog there are between index entries by default. At
+// 1MB an index of a 20GB oplog has about 20,000 entries and takes about 320KB.
+const DefaultInterval = 1024 * 1024
+
+// magic starts every index file, followed by the version
+var magic = [8]byte{'O', 'P', 'L', 'O', 'G', 'I', 'D', 'X'}
+
+const version = 2
+
+// Entry is an oplog entry's ts and where it starts in the file
+type Entry struct {
+	Timestamp bson.MongoTimestamp
+	Offset    int64
+}
+
+// Index is a sparse index from ts to byte offset of a BSON oplog dump, so a replay can start
+// part way through a large file without reading everything before it
+type Index struct {
+	// Size is the size of the file that was indexed, used to tell if the index is stale
+	Size int64
+	// ModTime is the modification time of the file that was indexed in Unix nanoseconds, which
+	// also has to match for the index to be used. It's zero for an index not built from a file.
+	ModTime int64
+	// Entries are in file order, so also in ts order
+	Entries []Entry
+}
+
+// header is the fixed size start of an index file
+type header struct {
+	Magic   [8]byte
+	Version uint32
+	Size    int64
+	ModTime int64
+	Count   int64
+}
+
+// Extension is added to the name of a file to get the name of its index
+const Extension = ".idx"
+
+// SidecarPath is where the index of a file is kept: next to it, with Extension added
+func SidecarPath(path string) string {
+	return path + Extension
+}
+
+// Build indexes an uncompressed BSON oplog, with an entry for the first oplog entry at least
+// interval bytes after the previous one
+func Build(r io.Reader, interval int64) (*Index, error) {
+	idx := &Index{}
+	scanner := bsonScanner.New(r)
+	next := int64(0)
+	for scanner.Scan() {
+		offset := scanner.Offset()
+		idx.Size = offset + int64(len(scanner.Bytes()))
+		if offset < next {
+			continue
+		}
+		var entry struct {
+			Timestamp bson.MongoTimestamp `bson:"ts"`
+		}
+		if err := bson.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Error reading the oplog entry at offset %d %s", offset, err)
+		}
+		idx.Entries = append(idx.Entries, Entry{Timestamp: entry.Timestamp, Offset: offset})
+		next = offset + interval
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error indexing oplog %s", err)
+	}
+	return idx, nil
+}
+
+// BuildFile indexes a local file
+func BuildFile(path string, interval int64) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening %s", err)
+	}
+	defer f.Close()
+	// Stat before reading, so a file changed while it's indexed looks stale next time
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s", err)
+	}
+	idx, err := Build(bufio.NewReaderSize(f, 1024*1024), interval)
+	if err != nil {
+		return nil, err
+	}
+	idx.ModTime = fi.ModTime().UnixNano()
+	return idx, nil
+}
+
+// Seek returns the offset to start reading at to find every entry after ts. It's the offset
+// of the last indexed entry at or before ts, so some entries before ts may still be read.
+func (idx *Index) Seek(ts bson.MongoTimestamp) int64 {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Timestamp > ts
+	})
+	if i == 0 {
+		return 0
+	}
+	return idx.Entries[i-1].Offset
+}
+
+// Range is part of an oplog file
+type Range struct {
+	// Start and End are byte offsets. End is exclusive.
+	Start int64
+	End   int64
+	// From is the ts of the first entry in the range
+	From bson.MongoTimestamp
+}
+
+// Split divides the file into at most parts ranges of about the same size, split at indexed
+// entries, so the work of replaying it can be divided
+func (idx *Index) Split(parts int) []Range {
+	ranges := []Range{}
+	if parts < 1 || len(idx.Entries) == 0 {
+		return ranges
+	}
+	target := idx.Size / int64(parts)
+	for _, entry := range idx.Entries {
+		if len(ranges) > 0 && entry.Offset-ranges[len(ranges)-1].Start < target {
+			continue
+		}
+		if len(ranges) > 0 {
+			ranges[len(ranges)-1].End = entry.Offset
+		}
+		ranges = append(ranges, Range{Start: entry.Offset, From: entry.Timestamp})
+	}
+	ranges[len(ranges)-1].End = idx.Size
+	return ranges
+}
+
+// Write saves the index
+func (idx *Index) Write(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	h := header{Magic: magic, Version: version, Size: idx.Size, ModTime: idx.ModTime, Count: int64(len(idx.Entries))}
+	if err := binary.Write(buffered, binary.LittleEndian, h); err != nil {
+		return fmt.Errorf("Error writing index %s", err)
+	}
+	if err := binary.Write(buffered, binary.LittleEndian, idx.Entries); err != nil {
+		return fmt.Errorf("Error writing index %s", err)
+	}
+	return buffered.Flush()
+}
+
+// Read loads an index saved by Write
+func Read(r io.Reader) (*Index, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("Error reading index %s", err)
+	}
+	if h.Magic != magic {
+		return nil, fmt.Errorf("Not an oplog index")
+	}
+	if h.Version != version {
+		return nil, fmt.Errorf("Unsupported index version %d", h.Version)
+	}
+	if h.Count < 0 || h.Count > h.Size {
+		return nil, fmt.Errorf("Corrupt index with %d entries for %d bytes", h.Count, h.Size)
+	}
+	idx := &Index{Size: h.Size, ModTime: h.ModTime, Entries: make([]Entry, h.Count)}
+	if err := binary.Read(r, binary.LittleEndian, idx.Entries); err != nil {
+		return nil, fmt.Errorf("Error reading index entries %s", err)
+	}
+	return idx, nil
+}
+
+// Save writes the index of path to its sidecar file
+func (idx *Index) Save(path string) error {
+	f, err := os.Create(SidecarPath(path))
+	if err != nil {
+		return fmt.Errorf("Error creating index %s", err)
+	}
+	if err := idx.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads the sidecar index of a local file, building it if it doesn't exist or is out of
+// date because the file's size or modification time changed. A built index is only saved if
+// save is set, and one that can't be saved is still returned, along with a warning.
+func Load(path string, interval int64, save bool) (idx *Index, warning error, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading %s", err)
+	}
+	if f, err := os.Open(SidecarPath(path)); err == nil {
+		idx, err := Read(bufio.NewReader(f))
+		f.Close()
+		if err == nil && idx.Size == fi.Size() && idx.ModTime == fi.ModTime().UnixNano() {
+			return idx, nil, nil
+		}
+	}
+	if idx, err = BuildFile(path, interval); err != nil {
+		return nil, nil, err
+	}
+	if !save {
+		return idx, nil, nil
+	}
+	return idx, idx.Save(path), nil
+}
@@ -0,0 +1,120 @@
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func timestamp(seconds int64, inc int64) bson.MongoTimestamp {
+	return bson.MongoTimestamp(seconds<<32 | inc)
+}
+
+// oplog returns count entries a second apart, each the same size, and that size
+func oplog(t *testing.T, count int) ([]byte, int64) {
+	buffer := &bytes.Buffer{}
+	var size int64
+	for i := 1; i <= count; i++ {
+		raw, err := bson.Marshal(bson.M{"ts": timestamp(int64(i), 1), "op": "n", "o": bson.M{"msg": strings.Repeat("x", 50)}})
+		assert.NoError(t, err)
+		size = int64(len(raw))
+		buffer.Write(raw)
+	}
+	return buffer.Bytes(), size
+}
+
+func TestBuildAndSeek(t *testing.T) {
+	log, size := oplog(t, 10)
+	// Every third entry is indexed
+	idx, err := Build(bytes.NewReader(log), 3*size)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(log)), idx.Size)
+	assert.Equal(t, []Entry{
+		{Timestamp: timestamp(1, 1), Offset: 0},
+		{Timestamp: timestamp(4, 1), Offset: 3 * size},
+		{Timestamp: timestamp(7, 1), Offset: 6 * size},
+		{Timestamp: timestamp(10, 1), Offset: 9 * size},
+	}, idx.Entries)
+
+	assert.Equal(t, int64(0), idx.Seek(0))
+	assert.Equal(t, int64(0), idx.Seek(timestamp(3, 1)))
+	assert.Equal(t, 3*size, idx.Seek(timestamp(4, 1)))
+	assert.Equal(t, 3*size, idx.Seek(timestamp(6, 5)))
+	assert.Equal(t, 9*size, idx.Seek(timestamp(20, 1)))
+}
+
+func TestSplit(t *testing.T) {
+	log, size := oplog(t, 10)
+	idx, err := Build(bytes.NewReader(log), size)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Range{
+		{Start: 0, End: 5 * size, From: timestamp(1, 1)},
+		{Start: 5 * size, End: 10 * size, From: timestamp(6, 1)},
+	}, idx.Split(2))
+	assert.Len(t, idx.Split(3), 3)
+	assert.Len(t, idx.Split(20), 10)
+	assert.Equal(t, []Range{}, (&Index{}).Split(2))
+}
+
+func TestReadWrite(t *testing.T) {
+	log, size := oplog(t, 10)
+	idx, err := Build(bytes.NewReader(log), 2*size)
+	assert.NoError(t, err)
+	idx.ModTime = time.Now().UnixNano()
+
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, idx.Write(buffer))
+	read, err := Read(bytes.NewReader(buffer.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, idx, read)
+
+	_, err = Read(strings.NewReader("not an index at all, just some text"))
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "oplog.bson")
+	log, size := oplog(t, 10)
+	assert.NoError(t, ioutil.WriteFile(path, log[:5*size], 0644))
+
+	// The index is built without writing the sidecar unless it's asked for
+	idx, warning, err := Load(path, size, false)
+	assert.NoError(t, err)
+	assert.NoError(t, warning)
+	assert.Len(t, idx.Entries, 5)
+	_, err = os.Stat(SidecarPath(path))
+	assert.True(t, os.IsNotExist(err))
+	idx, warning, err = Load(path, size, true)
+	assert.NoError(t, err)
+	assert.NoError(t, warning)
+	_, err = os.Stat(SidecarPath(path))
+	assert.NoError(t, err)
+
+	// It's rebuilt when the file changes, even if the size stays the same
+	assert.NoError(t, ioutil.WriteFile(path, log[5*size:], 0644))
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	idx, _, err = Load(path, size, true)
+	assert.NoError(t, err)
+	assert.Equal(t, timestamp(6, 1), idx.Entries[0].Timestamp)
+
+	// and when it changes size
+	assert.NoError(t, ioutil.WriteFile(path, log, 0644))
+	idx, _, err = Load(path, size, true)
+	assert.NoError(t, err)
+	assert.Len(t, idx.Entries, 10)
+	saved, _, err := Load(path, 100*size, false)
+	assert.NoError(t, err)
+	assert.Equal(t, idx, saved)
+}
@@ -0,0 +1,53 @@
+package input
+
+import (
+	"io"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Between returns the oplog entries in r after from, up to and including until. Entries at
+// or before from are dropped until the first one after it, and reading stops at the first
+// entry after until. A from or until of zero isn't a limit. The entries in r should already
+// have been checked, like a Merger's.
+func Between(r io.Reader, from, until bson.MongoTimestamp) io.Reader {
+	return &betweenReader{scanner: bsonScanner.NewUnchecked(r), from: from, until: until}
+}
+
+type betweenReader struct {
+	scanner *bsonScanner.Scanner
+	from    bson.MongoTimestamp
+	until   bson.MongoTimestamp
+	started bool
+	pending []byte
+	err     error
+}
+
+func (b *betweenReader) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if !b.scanner.Scan() {
+			b.err = b.scanner.Err()
+			if b.err == nil {
+				b.err = io.EOF
+			}
+			continue
+		}
+		ts := timestampOf(b.scanner.Bytes())
+		if !b.started && b.from != 0 && ts <= b.from {
+			continue
+		}
+		b.started = true
+		if b.until != 0 && ts > b.until {
+			b.err = io.EOF
+			continue
+		}
+		b.pending = b.scanner.Bytes()
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,32 @@
+package input
+
+import (
+	"bytes"
+	"testing"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestBetween(t *testing.T) {
+	log := oplog(t, timestamp(100, 1), timestamp(100, 2), timestamp(150, 1), timestamp(200, 1), timestamp(250, 1))
+	for _, test := range []struct {
+		from, until bson.MongoTimestamp
+		expected    []bson.MongoTimestamp
+	}{
+		{0, 0, []bson.MongoTimestamp{timestamp(100, 1), timestamp(100, 2), timestamp(150, 1), timestamp(200, 1), timestamp(250, 1)}},
+		{timestamp(100, 2), 0, []bson.MongoTimestamp{timestamp(150, 1), timestamp(200, 1), timestamp(250, 1)}},
+		{timestamp(120, 0), timestamp(200, 1), []bson.MongoTimestamp{timestamp(150, 1), timestamp(200, 1)}},
+		{0, timestamp(100, 1), []bson.MongoTimestamp{timestamp(100, 1)}},
+		{timestamp(300, 0), 0, []bson.MongoTimestamp{}},
+	} {
+		scanner := bsonScanner.New(Between(bytes.NewReader(log), test.from, test.until))
+		timestamps := []bson.MongoTimestamp{}
+		for scanner.Scan() {
+			timestamps = append(timestamps, timestampOf(scanner.Bytes()))
+		}
+		assert.NoError(t, scanner.Err())
+		assert.Equal(t, test.expected, timestamps)
+	}
+}
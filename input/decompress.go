@@ -0,0 +1,116 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a compression format an input can be in
+type Compression string
+
+// The compression formats Decompress understands
+const (
+	None   Compression = "none"
+	Gzip   Compression = "gzip"
+	Zstd   Compression = "zstd"
+	Snappy Compression = "snappy"
+	Bzip2  Compression = "bzip2"
+)
+
+var extensions = map[string]Compression{
+	".gz":     Gzip,
+	".gzip":   Gzip,
+	".zst":    Zstd,
+	".zstd":   Zstd,
+	".sz":     Snappy,
+	".snappy": Snappy,
+	".s2":     Snappy,
+	".bz2":    Bzip2,
+	".bson":   None,
+}
+
+var magics = []struct {
+	magic       []byte
+	compression Compression
+}{
+	{[]byte{0x1f, 0x8b}, Gzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, Zstd},
+	{[]byte("\xff\x06\x00\x00sNaPpY"), Snappy},
+	{[]byte("\xff\x06\x00\x00S2sTwO"), Snappy},
+	{[]byte("BZh"), Bzip2},
+}
+
+// headerSize is enough of the start of an input to recognize any of the formats
+const headerSize = 10
+
+// Detect works out the compression of an input from its name and first few bytes. A known
+// extension wins, so a raw BSON dump that happens to start with a magic number is still read
+// correctly as long as it ends in .bson.
+func Detect(name string, header []byte) Compression {
+	if compression, ok := extensions[strings.ToLower(filepath.Ext(name))]; ok {
+		return compression
+	}
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.compression
+		}
+	}
+	return None
+}
+
+// Decompress detects the compression of r and returns a reader of the decompressed data.
+// Closing it releases the decompressor, but doesn't close r.
+func Decompress(r io.Reader, name string) (io.ReadCloser, Compression, error) {
+	buffered := bufio.NewReader(r)
+	// A short input can't be compressed, so an error here is left for the caller to find
+	header, _ := buffered.Peek(headerSize)
+
+	compression := Detect(name, header)
+	switch compression {
+	case Gzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, compression, fmt.Errorf("Error reading gzip header of %s %s", name, err)
+		}
+		return gz, compression, nil
+	case Zstd:
+		// Decode on a single goroutine with less buffering to keep memory low in small containers
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, compression, fmt.Errorf("Error reading zstd header of %s %s", name, err)
+		}
+		return decoder.IOReadCloser(), compression, nil
+	case Snappy:
+		return ioutil.NopCloser(s2.NewReader(buffered)), compression, nil
+	case Bzip2:
+		return ioutil.NopCloser(bzip2.NewReader(buffered)), compression, nil
+	default:
+		return ioutil.NopCloser(buffered), None, nil
+	}
+}
+
+// DetectFile detects the compression of a local file
+func DetectFile(path string) (Compression, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return None, err
+	}
+	defer f.Close()
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return None, err
+	}
+	return Detect(path, header[:n]), nil
+}
@@ -0,0 +1,106 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const testData = "some oplog entries, repeated, some oplog entries, repeated"
+
+func compress(t *testing.T, compression Compression) []byte {
+	buffer := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch compression {
+	case Gzip:
+		w = gzip.NewWriter(buffer)
+	case Zstd:
+		var err error
+		w, err = zstd.NewWriter(buffer)
+		assert.NoError(t, err)
+	case Snappy:
+		w = s2.NewWriter(buffer, s2.WriterSnappyCompat())
+	default:
+		t.Fatalf("Can't compress %s", compression)
+	}
+	_, err := w.Write([]byte(testData))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buffer.Bytes()
+}
+
+// bzip2Data is testData compressed with the bzip2 command line tool, since Go only has a decompressor
+var bzip2Data = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x4a, 0x0a, 0x02, 0xad, 0x00, 0x00,
+	0x1a, 0x11, 0x80, 0x40, 0x04, 0x26, 0xa7, 0xdc, 0x00, 0x20, 0x00, 0x50, 0xa6, 0x00, 0x00, 0x2a,
+	0xa8, 0xda, 0x9e, 0xa6, 0x4d, 0x1e, 0x52, 0x52, 0x8e, 0x29, 0xdc, 0x1e, 0x3e, 0x5b, 0x4b, 0x7a,
+	0xb6, 0x12, 0xc3, 0x2a, 0x6d, 0x94, 0x3a, 0x61, 0xa4, 0x37, 0x14, 0xb7, 0xe2, 0xee, 0x48, 0xa7,
+	0x0a, 0x12, 0x09, 0x41, 0x40, 0x55, 0xa0,
+}
+
+func TestDecompress(t *testing.T) {
+	for _, compression := range []Compression{Gzip, Zstd, Snappy} {
+		// Detected by magic bytes, without an extension
+		r, detected, err := Decompress(bytes.NewReader(compress(t, compression)), "s3://bucket/oplog")
+		assert.NoError(t, err)
+		assert.Equal(t, compression, detected)
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, testData, string(data), "%s", compression)
+		assert.NoError(t, r.Close())
+	}
+
+	r, detected, err := Decompress(bytes.NewReader(bzip2Data), "oplog.bson.bz2")
+	assert.NoError(t, err)
+	assert.Equal(t, Bzip2, detected)
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, string(data))
+
+	r, detected, err = Decompress(bytes.NewReader([]byte(testData)), "oplog.bson")
+	assert.NoError(t, err)
+	assert.Equal(t, None, detected)
+	data, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, string(data))
+}
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, Gzip, Detect("oplog.bson.gz", nil))
+	assert.Equal(t, Zstd, Detect("oplog.ZST", nil))
+	assert.Equal(t, Snappy, Detect("oplog.sz", nil))
+	assert.Equal(t, Bzip2, Detect("oplog", []byte("BZh91AY")))
+	// An extension wins over the magic bytes
+	assert.Equal(t, None, Detect("oplog.bson", []byte{0x1f, 0x8b, 0x08, 0x00}))
+	assert.Equal(t, None, Detect("oplog", []byte{0x10, 0x00, 0x00, 0x00}))
+}
+
+func TestOpenPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	raw := filepath.Join(dir, "first.bson")
+	assert.NoError(t, ioutil.WriteFile(raw, []byte("first "), 0644))
+	gzipped := filepath.Join(dir, "second")
+	assert.NoError(t, ioutil.WriteFile(gzipped, compress(t, Gzip), 0644))
+
+	r := StreamPaths(context.Background(), []string{raw, gzipped}, DefaultMaxReopens, FormatAuto, "")
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "first "+testData, string(data))
+
+	assert.Equal(t, int64(6), KnownSize([]string{raw}, FormatAuto))
+	assert.Equal(t, int64(0), KnownSize([]string{raw, gzipped}, FormatAuto))
+	assert.Equal(t, int64(0), KnownSize([]string{"s3://bucket/oplog.bson"}, FormatAuto))
+}
@@ -0,0 +1,104 @@
+package input
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Clever/mongo-op-throttler/index"
+	"github.com/Clever/pathio"
+)
+
+// ExpandPaths turns a list of paths, directories and glob patterns into the files they match.
+// Directories, local or ending in "/" for other pathio paths like S3 prefixes, are expanded
+// to the files directly in them. Glob patterns can only have wildcards in the last part of
+// the path. Index sidecar files are left out of directories and globs. Each argument's matches
+// are sorted, and it's an error for one to match nothing.
+func ExpandPaths(patterns []string) ([]string, error) {
+	paths := []string{}
+	for _, pattern := range patterns {
+		matches, err := expand(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match %s", pattern)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// isSidecar is whether a file is the index of another file rather than an input
+func isSidecar(name string) bool {
+	return strings.HasSuffix(name, index.Extension)
+}
+
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+func expand(pattern string) ([]string, error) {
+	if isLocal(pattern) {
+		local := strings.TrimPrefix(pattern, "file://")
+		if hasGlob(local) {
+			matches, err := filepath.Glob(local)
+			if err != nil {
+				return nil, err
+			}
+			files := []string{}
+			for _, match := range matches {
+				if !isSidecar(match) {
+					files = append(files, match)
+				}
+			}
+			return files, nil
+		}
+		fi, err := os.Stat(local)
+		if err != nil || !fi.IsDir() {
+			return []string{pattern}, nil
+		}
+		infos, err := ioutil.ReadDir(local)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing %s %s", pattern, err)
+		}
+		files := []string{}
+		for _, info := range infos {
+			if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") && !isSidecar(info.Name()) {
+				files = append(files, filepath.Join(local, info.Name()))
+			}
+		}
+		return files, nil
+	}
+
+	if !hasGlob(pattern) && !strings.HasSuffix(pattern, "/") {
+		return []string{pattern}, nil
+	}
+	dir := pattern[:strings.LastIndex(pattern, "/")+1]
+	if hasGlob(dir) {
+		return nil, fmt.Errorf("Only the last part of %s can have wildcards", pattern)
+	}
+	listed, err := pathio.ListFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing %s %s", dir, err)
+	}
+	files := []string{}
+	for _, file := range listed {
+		// Make sure each file is a full path, however pathio lists them
+		if !strings.Contains(file, "://") {
+			file = dir + path.Base(file)
+		}
+		if isSidecar(file) {
+			continue
+		}
+		if matched, _ := path.Match(pattern, file); !hasGlob(pattern) || matched {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
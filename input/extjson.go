@@ -0,0 +1,113 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	driverbson "go.mongodb.org/mongo-driver/bson"
+)
+
+// Format is the encoding of the oplog entries in an input
+type Format string
+
+// The formats inputs can be read in
+const (
+	// FormatAuto reads inputs named .json, .jsonl or .ndjson as Extended JSON and the rest as BSON
+	FormatAuto    Format = "auto"
+	FormatBSON    Format = "bson"
+	FormatExtJSON Format = "extjson"
+)
+
+// maxExtJSONLine is the longest Extended JSON line read. It's well over the 16MB BSON limit
+// since JSON, and base64 in particular, takes more space than the BSON it encodes.
+const maxExtJSONLine = 64 * 1024 * 1024
+
+var jsonExtensions = map[string]bool{".json": true, ".jsonl": true, ".ndjson": true}
+
+// ParseFormat checks a format given as a flag or in a config file
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case FormatAuto, FormatBSON, FormatExtJSON:
+		return Format(value), nil
+	}
+	return "", fmt.Errorf("Unknown input format %q, it must be auto, bson or extjson", value)
+}
+
+// FormatOf resolves FormatAuto to the format of the named input, ignoring any compression
+// extension, so oplog.jsonl.gz is Extended JSON
+func FormatOf(name string, format Format) Format {
+	if format != FormatAuto {
+		return format
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if _, ok := extensions[ext]; ok && ext != ".bson" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+	if jsonExtensions[ext] {
+		return FormatExtJSON
+	}
+	return FormatBSON
+}
+
+// Documents returns the raw BSON oplog entries in an already decompressed input. Extended
+// JSON is converted to BSON, mongodump archives are read as the documents in
+// archiveNamespace, and plain BSON dumps are returned as they are.
+func Documents(r io.Reader, name string, format Format, archiveNamespace string) io.Reader {
+	if FormatOf(name, format) == FormatExtJSON {
+		return NewExtJSONReader(r, name)
+	}
+	return Extract(r, archiveNamespace)
+}
+
+// ExtJSONReader converts line delimited MongoDB Extended JSON v2, canonical or relaxed, to a
+// stream of BSON documents. Blank lines are skipped.
+type ExtJSONReader struct {
+	name    string
+	lines   *bufio.Scanner
+	line    int
+	pending []byte
+	err     error
+}
+
+// NewExtJSONReader returns an ExtJSONReader of r. The name is only used in errors.
+func NewExtJSONReader(r io.Reader, name string) *ExtJSONReader {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(make([]byte, 64*1024), maxExtJSONLine)
+	return &ExtJSONReader{name: name, lines: lines}
+}
+
+func (e *ExtJSONReader) Read(b []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		if !e.lines.Scan() {
+			e.err = e.lines.Err()
+			if e.err == nil {
+				e.err = io.EOF
+			} else {
+				e.err = fmt.Errorf("Error reading line %d of %s %s", e.line+1, e.name, e.err)
+			}
+			continue
+		}
+		e.line++
+		line := bytes.TrimSpace(e.lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var doc driverbson.Raw
+		// Relaxed parsing also understands the canonical forms, like {"$numberInt": "1"}
+		if err := driverbson.UnmarshalExtJSON(line, false, &doc); err != nil {
+			e.err = fmt.Errorf("Error parsing Extended JSON on line %d of %s %s", e.line, e.name, err)
+			continue
+		}
+		e.pending = doc
+	}
+	n := copy(b, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
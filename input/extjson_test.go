@@ -0,0 +1,96 @@
+package input
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const canonicalEntry = `{"ts": {"$timestamp": {"t": 1500000000, "i": 3}}, "h": {"$numberLong": "-42"}, "v": {"$numberInt": "2"}, "op": "i", "ns": "clever.schools",` +
+	` "o": {"_id": {"$oid": "5966d8b4f0d5d0a8b4f0d5d0"}, "created": {"$date": {"$numberLong": "1499990400000"}}, "score": {"$numberDouble": "1.5"}, "data": {"$binary": {"base64": "aGk=", "subType": "00"}}}}`
+
+const relaxedEntry = `{"ts": {"$timestamp": {"t": 1500000001, "i": 1}}, "op": "u", "ns": "clever.schools", "o2": {"_id": {"$oid": "5966d8b4f0d5d0a8b4f0d5d0"}},` +
+	` "o": {"$set": {"created": {"$date": "2017-07-14T00:00:00Z"}, "count": 7, "big": {"$numberLong": "9007199254740993"}}}}`
+
+func readEntries(t *testing.T, data string) []bson.M {
+	scanner := bsonScanner.New(NewExtJSONReader(strings.NewReader(data), "oplog.jsonl"))
+	entries := []bson.M{}
+	for scanner.Scan() {
+		entry := bson.M{}
+		assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestExtJSONReader(t *testing.T) {
+	entries := readEntries(t, canonicalEntry+"\n\n"+relaxedEntry+"\n")
+	assert.Equal(t, 2, len(entries))
+
+	id := bson.ObjectIdHex("5966d8b4f0d5d0a8b4f0d5d0")
+	insert := entries[0]
+	assert.Equal(t, bson.MongoTimestamp(1500000000<<32|3), insert["ts"])
+	assert.Equal(t, int64(-42), insert["h"])
+	assert.Equal(t, 2, insert["v"])
+	assert.Equal(t, "clever.schools", insert["ns"])
+	doc := insert["o"].(bson.M)
+	assert.Equal(t, id, doc["_id"])
+	assert.Equal(t, time.Unix(1499990400, 0).UTC(), doc["created"].(time.Time).UTC())
+	assert.Equal(t, 1.5, doc["score"])
+	assert.Equal(t, []byte("hi"), doc["data"])
+
+	update := entries[1]
+	assert.Equal(t, id, update["o2"].(bson.M)["_id"])
+	set := update["o"].(bson.M)["$set"].(bson.M)
+	assert.Equal(t, time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC), set["created"].(time.Time).UTC())
+	assert.Equal(t, 7, set["count"])
+	assert.Equal(t, int64(9007199254740993), set["big"])
+}
+
+func TestExtJSONReaderError(t *testing.T) {
+	_, err := ioutil.ReadAll(NewExtJSONReader(strings.NewReader(canonicalEntry+"\n{\"ts\": \n"), "oplog.jsonl"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Error parsing Extended JSON on line 2 of oplog.jsonl")
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, FormatExtJSON, FormatOf("s3://bucket/oplog.jsonl", FormatAuto))
+	assert.Equal(t, FormatExtJSON, FormatOf("oplog.JSON.gz", FormatAuto))
+	assert.Equal(t, FormatExtJSON, FormatOf("oplog.ndjson.zst", FormatAuto))
+	assert.Equal(t, FormatBSON, FormatOf("oplog.bson", FormatAuto))
+	assert.Equal(t, FormatBSON, FormatOf("oplog.gz", FormatAuto))
+	assert.Equal(t, FormatBSON, FormatOf("oplog", FormatAuto))
+	assert.Equal(t, FormatExtJSON, FormatOf("oplog", FormatExtJSON))
+	assert.Equal(t, FormatBSON, FormatOf("oplog.json", FormatBSON))
+
+	_, err := ParseFormat("csv")
+	assert.Error(t, err)
+}
+
+func TestOpenPathsExtJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	jsonl := filepath.Join(dir, "oplog.jsonl")
+	assert.NoError(t, ioutil.WriteFile(jsonl, []byte(canonicalEntry+"\n"), 0644))
+
+	r := StreamPaths(context.Background(), []string{jsonl}, DefaultMaxReopens, FormatAuto, "")
+	defer r.Close()
+	scanner := bsonScanner.New(r)
+	assert.True(t, scanner.Scan())
+	entry := bson.M{}
+	assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &entry))
+	assert.Equal(t, "i", entry["op"])
+	assert.False(t, scanner.Scan())
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, int64(0), KnownSize([]string{jsonl}, FormatAuto))
+}
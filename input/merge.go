@@ -0,0 +1,258 @@
+package input
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FileRange is the span of oplog timestamps read from one input path
+type FileRange struct {
+	Path    string
+	First   bson.MongoTimestamp
+	Last    bson.MongoTimestamp
+	Entries int
+}
+
+// mergeSource is one input being merged, positioned at its next entry. Before it's opened
+// ts is the timestamp of its first entry.
+type mergeSource struct {
+	scanner *bsonScanner.Scanner
+	closer  io.Closer
+	ts      bson.MongoTimestamp
+	rng     *FileRange
+	// index is the position of the path in the list given to Merge
+	index int
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+// Less orders by timestamp, and keeps the order the paths were given in for ties so merging
+// entries without timestamps is the same as reading the paths one after another
+func (h mergeHeap) Less(i, j int) bool {
+	return before(h[i], h[j])
+}
+
+func before(a, b *mergeSource) bool {
+	if a.ts != b.ts {
+		return a.ts < b.ts
+	}
+	return a.index < b.index
+}
+
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Merger reads several oplogs at once and returns their entries in timestamp order, like a
+// single oplog. Each input should already be in timestamp order, as oplogs are. Inputs are
+// only kept open while their entries are being merged, so inputs that don't overlap are read
+// one at a time.
+type Merger struct {
+	paths   []string
+	open    func(path string) io.ReadCloser
+	started bool
+	heap    mergeHeap
+	// waiting are the inputs that haven't been opened yet, in order of their first entries
+	waiting []*mergeSource
+	ranges  []*FileRange
+	pending []byte
+	err     error
+	onSkip  func(path string, skip bsonScanner.Skip)
+}
+
+// Merge returns a Merger of the paths. Each path is opened, decompressed and converted to
+// BSON documents like OpenPaths does.
+func Merge(paths []string, open OpenFunc, format Format, archiveNamespace string) *Merger {
+	return &Merger{
+		paths: paths,
+		open: func(path string) io.ReadCloser {
+			return OpenPaths([]string{path}, open, format, archiveNamespace)
+		},
+	}
+}
+
+// timestampOf reads the ts of a raw oplog entry. Entries without one sort first.
+func timestampOf(raw []byte) bson.MongoTimestamp {
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	bson.Unmarshal(raw, &entry)
+	return entry.Timestamp
+}
+
+// advance moves a source to its next entry and puts it back in the heap, or closes it if
+// it's finished
+func (m *Merger) advance(source *mergeSource) error {
+	if !source.scanner.Scan() {
+		source.closer.Close()
+		if err := source.scanner.Err(); err != nil {
+			return fmt.Errorf("Error reading %s %s", source.rng.Path, err)
+		}
+		return nil
+	}
+	source.ts = timestampOf(source.scanner.Bytes())
+	if source.rng.Entries == 0 {
+		source.rng.First = source.ts
+	}
+	source.rng.Last = source.ts
+	source.rng.Entries++
+	heap.Push(&m.heap, source)
+	return nil
+}
+
+// scanner returns a Scanner of an input, which skips corrupt data if onSkip is set
+func (m *Merger) scanner(path string, r io.Reader, onSkip func(path string, skip bsonScanner.Skip)) *bsonScanner.Scanner {
+	if onSkip == nil {
+		return bsonScanner.New(r)
+	}
+	return bsonScanner.NewRecovering(r, func(skip bsonScanner.Skip) {
+		onSkip(path, skip)
+	})
+}
+
+// firstTimestamp opens an input just long enough to read the timestamp of its first entry.
+// If it can't be read, the input sorts first so the error is found when it's read for real.
+func (m *Merger) firstTimestamp(path string) bson.MongoTimestamp {
+	r := m.open(path)
+	defer r.Close()
+	// Skips are reported when the input is read for real
+	var quiet func(string, bsonScanner.Skip)
+	if m.onSkip != nil {
+		quiet = func(string, bsonScanner.Skip) {}
+	}
+	scanner := m.scanner(path, r, quiet)
+	if !scanner.Scan() {
+		return 0
+	}
+	return timestampOf(scanner.Bytes())
+}
+
+func (m *Merger) start() {
+	for i, path := range m.paths {
+		rng := &FileRange{Path: path}
+		m.ranges = append(m.ranges, rng)
+		source := &mergeSource{rng: rng, index: i}
+		if len(m.paths) > 1 {
+			source.ts = m.firstTimestamp(path)
+		}
+		m.waiting = append(m.waiting, source)
+	}
+	sort.SliceStable(m.waiting, func(i, j int) bool {
+		return m.waiting[i].ts < m.waiting[j].ts
+	})
+}
+
+// openWaiting opens the inputs that could have the next entry: every one whose first entry
+// doesn't come after the next entry of the inputs already open
+func (m *Merger) openWaiting() error {
+	for len(m.waiting) > 0 && (m.heap.Len() == 0 || !before(m.heap[0], m.waiting[0])) {
+		source := m.waiting[0]
+		m.waiting = m.waiting[1:]
+		r := m.open(source.rng.Path)
+		source.scanner = m.scanner(source.rng.Path, r, m.onSkip)
+		source.closer = r
+		if err := m.advance(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recover makes the Merger skip over corrupt data in its inputs instead of failing, calling
+// onSkip with the path and range of each part it skipped. It must be called before Read.
+func (m *Merger) Recover(onSkip func(path string, skip bsonScanner.Skip)) {
+	m.onSkip = onSkip
+}
+
+// Read implements io.Reader
+func (m *Merger) Read(p []byte) (int, error) {
+	for len(m.pending) == 0 {
+		if m.err != nil {
+			return 0, m.err
+		}
+		if !m.started {
+			m.started = true
+			m.start()
+		}
+		if m.err = m.openWaiting(); m.err != nil {
+			continue
+		}
+		if m.heap.Len() == 0 {
+			m.err = io.EOF
+			continue
+		}
+		source := heap.Pop(&m.heap).(*mergeSource)
+		// The scanner reuses its buffer, so copy the entry before moving on
+		m.pending = append([]byte{}, source.scanner.Bytes()...)
+		m.err = m.advance(source)
+	}
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+// Close closes any inputs that haven't been read to the end
+func (m *Merger) Close() error {
+	for _, source := range m.heap {
+		source.closer.Close()
+	}
+	m.heap = nil
+	m.waiting = nil
+	return nil
+}
+
+// Ranges returns the timestamps read from each path so far, in the order the paths were given
+func (m *Merger) Ranges() []FileRange {
+	ranges := []FileRange{}
+	for _, rng := range m.ranges {
+		ranges = append(ranges, *rng)
+	}
+	return ranges
+}
+
+func timestampTime(ts bson.MongoTimestamp) time.Time {
+	return time.Unix(int64(ts)>>32, 0).UTC()
+}
+
+// CheckRanges looks for inputs that overlap, or that have more than maxGap between them,
+// which usually means a file is missing. A maxGap of zero doesn't check for gaps.
+func CheckRanges(ranges []FileRange, maxGap time.Duration) []string {
+	nonEmpty := []FileRange{}
+	for _, rng := range ranges {
+		if rng.Entries > 0 {
+			nonEmpty = append(nonEmpty, rng)
+		}
+	}
+	sort.SliceStable(nonEmpty, func(i, j int) bool {
+		return nonEmpty[i].First < nonEmpty[j].First
+	})
+
+	problems := []string{}
+	for i := 1; i < len(nonEmpty); i++ {
+		prev, next := nonEmpty[i-1], nonEmpty[i]
+		if next.First <= prev.Last {
+			problems = append(problems, fmt.Sprintf("%s overlaps %s: it starts at %s, before %s ends at %s",
+				next.Path, prev.Path, timestampTime(next.First).Format(time.RFC3339), prev.Path, timestampTime(prev.Last).Format(time.RFC3339)))
+			continue
+		}
+		gap := timestampTime(next.First).Sub(timestampTime(prev.Last))
+		if maxGap > 0 && gap > maxGap {
+			problems = append(problems, fmt.Sprintf("%s gap between %s and %s, from %s to %s",
+				gap, prev.Path, next.Path, timestampTime(prev.Last).Format(time.RFC3339), timestampTime(next.First).Format(time.RFC3339)))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,196 @@
+package input
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/index"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func timestamp(seconds int64, inc int64) bson.MongoTimestamp {
+	return bson.MongoTimestamp(seconds<<32 | inc)
+}
+
+func oplog(t *testing.T, timestamps ...bson.MongoTimestamp) []byte {
+	buffer := &bytes.Buffer{}
+	for _, ts := range timestamps {
+		raw, err := bson.Marshal(bson.M{"ts": ts, "op": "n"})
+		assert.NoError(t, err)
+		buffer.Write(raw)
+	}
+	return buffer.Bytes()
+}
+
+func TestMerge(t *testing.T) {
+	files := map[string][]byte{
+		"a": oplog(t, timestamp(100, 1), timestamp(100, 3), timestamp(200, 1)),
+		"b": oplog(t, timestamp(100, 2), timestamp(150, 1)),
+		"c": oplog(t),
+	}
+	open := func(path string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(files[path])), nil
+	}
+
+	merger := Merge([]string{"a", "b", "c"}, open, FormatBSON, "")
+	scanner := bsonScanner.New(merger)
+	timestamps := []bson.MongoTimestamp{}
+	for scanner.Scan() {
+		timestamps = append(timestamps, timestampOf(scanner.Bytes()))
+	}
+	assert.NoError(t, scanner.Err())
+	assert.NoError(t, merger.Close())
+	assert.Equal(t, []bson.MongoTimestamp{
+		timestamp(100, 1), timestamp(100, 2), timestamp(100, 3), timestamp(150, 1), timestamp(200, 1),
+	}, timestamps)
+
+	assert.Equal(t, []FileRange{
+		{Path: "a", First: timestamp(100, 1), Last: timestamp(200, 1), Entries: 3},
+		{Path: "b", First: timestamp(100, 2), Last: timestamp(150, 1), Entries: 2},
+		{Path: "c"},
+	}, merger.Ranges())
+}
+
+func TestMergeOpensInputsWhenNeeded(t *testing.T) {
+	files := map[string][]byte{
+		// Given out of order, and c overlaps b
+		"a": oplog(t, timestamp(300, 1), timestamp(400, 1)),
+		"b": oplog(t, timestamp(100, 1), timestamp(200, 1)),
+		"c": oplog(t, timestamp(150, 1), timestamp(160, 1)),
+		"d": oplog(t, timestamp(500, 1)),
+	}
+	open, maxOpen := 0, 0
+	opener := func(path string) (io.ReadCloser, error) {
+		open++
+		if open > maxOpen {
+			maxOpen = open
+		}
+		return closeFunc{bytes.NewReader(files[path]), func() { open-- }}, nil
+	}
+
+	merger := Merge([]string{"a", "b", "c", "d"}, opener, FormatBSON, "")
+	scanner := bsonScanner.New(merger)
+	timestamps := []bson.MongoTimestamp{}
+	for scanner.Scan() {
+		timestamps = append(timestamps, timestampOf(scanner.Bytes()))
+		if timestampOf(scanner.Bytes()) >= timestamp(300, 1) {
+			// At most the input being read is open once the overlapping ones are done
+			assert.True(t, open <= 1)
+		}
+	}
+	assert.NoError(t, scanner.Err())
+	assert.NoError(t, merger.Close())
+	assert.Equal(t, []bson.MongoTimestamp{
+		timestamp(100, 1), timestamp(150, 1), timestamp(160, 1), timestamp(200, 1),
+		timestamp(300, 1), timestamp(400, 1), timestamp(500, 1),
+	}, timestamps)
+	assert.Equal(t, 2, maxOpen)
+	assert.Equal(t, 0, open)
+}
+
+// closeFunc is a ReadCloser that calls a function when it's closed
+type closeFunc struct {
+	io.Reader
+	close func()
+}
+
+func (c closeFunc) Close() error {
+	c.close()
+	return nil
+}
+
+func TestCheckRanges(t *testing.T) {
+	hour := int64(3600)
+	ranges := []FileRange{
+		{Path: "02", First: timestamp(2*hour, 1), Last: timestamp(3*hour-1, 1), Entries: 10},
+		{Path: "00", First: timestamp(0, 1), Last: timestamp(hour-1, 1), Entries: 10},
+		{Path: "01", First: timestamp(hour, 1), Last: timestamp(2*hour+5, 1), Entries: 10},
+		{Path: "empty"},
+		{Path: "05", First: timestamp(5*hour, 1), Last: timestamp(6*hour-1, 1), Entries: 10},
+	}
+	assert.Equal(t, []string{
+		"02 overlaps 01: it starts at 1970-01-01T02:00:00Z, before 01 ends at 1970-01-01T02:00:05Z",
+		"2h0m1s gap between 02 and 05, from 1970-01-01T02:59:59Z to 1970-01-01T05:00:00Z",
+	}, CheckRanges(ranges, time.Hour))
+	assert.Equal(t, 1, len(CheckRanges(ranges, 0)))
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"oplog-02.bson", "oplog-01.bson", "other.txt", ".hidden"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0755))
+
+	paths, err := ExpandPaths([]string{filepath.Join(dir, "oplog-*.bson")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "oplog-01.bson"), filepath.Join(dir, "oplog-02.bson")}, paths)
+
+	paths, err = ExpandPaths([]string{dir, "s3://bucket/oplog.bson"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "oplog-01.bson"), filepath.Join(dir, "oplog-02.bson"), filepath.Join(dir, "other.txt"),
+		"s3://bucket/oplog.bson",
+	}, paths)
+
+	_, err = ExpandPaths([]string{filepath.Join(dir, "*.gz")})
+	assert.Error(t, err)
+	assert.Equal(t, "No files match "+filepath.Join(dir, "*.gz"), err.Error())
+
+	_, err = ExpandPaths([]string{"s3://bucket/*/oplog.bson"})
+	assert.Error(t, err)
+	assert.Equal(t, "Only the last part of s3://bucket/*/oplog.bson can have wildcards", err.Error())
+}
+
+func TestExpandPathsSkipsIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oplogPath := filepath.Join(dir, "oplog.bson")
+	assert.NoError(t, ioutil.WriteFile(oplogPath, nil, 0644))
+	assert.NoError(t, ioutil.WriteFile(index.SidecarPath(oplogPath), nil, 0644))
+
+	for _, pattern := range []string{dir, filepath.Join(dir, "*")} {
+		paths, err := ExpandPaths([]string{pattern})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{oplogPath}, paths)
+	}
+	// Unless it's asked for by name
+	paths, err := ExpandPaths([]string{index.SidecarPath(oplogPath)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{index.SidecarPath(oplogPath)}, paths)
+}
+
+func TestMergeRecover(t *testing.T) {
+	first := oplog(t, timestamp(100, 1))
+	second := oplog(t, timestamp(200, 1))
+	corrupt := append(append(append([]byte{}, first...), "garbage"...), second...)
+	open := func(path string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(corrupt)), nil
+	}
+
+	_, err := ioutil.ReadAll(Merge([]string{"corrupt"}, open, FormatBSON, ""))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("Error reading corrupt Corrupt BSON document at offset %d", len(first)))
+
+	merger := Merge([]string{"corrupt"}, open, FormatBSON, "")
+	skipped := []string{}
+	merger.Recover(func(path string, skip bsonScanner.Skip) {
+		skipped = append(skipped, path)
+		assert.Equal(t, bsonScanner.Skip{Offset: int64(len(first)), Length: 7, Reason: skip.Reason}, skip)
+	})
+	recovered, err := ioutil.ReadAll(merger)
+	assert.NoError(t, err)
+	assert.Equal(t, append(first, second...), recovered)
+	assert.Equal(t, []string{"corrupt"}, skipped)
+}
@@ -0,0 +1,157 @@
+package input
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+)
+
+// OpenFunc opens a single input path
+type OpenFunc func(path string) (io.ReadCloser, error)
+
+// OpenPaths returns a reader of the decompressed contents of each path one after another.
+// Each path is only opened once the ones before it have been read, and is closed when it's
+// finished. Each path can be compressed differently, and is read as BSON documents like
+// Documents does.
+func OpenPaths(paths []string, open OpenFunc, format Format, archiveNamespace string) io.ReadCloser {
+	return &pathsReader{paths: paths, open: open, format: format, archiveNamespace: archiveNamespace}
+}
+
+type pathsReader struct {
+	paths            []string
+	open             OpenFunc
+	format           Format
+	archiveNamespace string
+	raw              io.ReadCloser
+	decompressed     io.ReadCloser
+	reader           io.Reader
+}
+
+func (p *pathsReader) Read(b []byte) (int, error) {
+	for {
+		if p.reader == nil {
+			if len(p.paths) == 0 {
+				return 0, io.EOF
+			}
+			raw, err := p.open(p.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			if _, ok := raw.(rawInput); ok {
+				p.raw, p.decompressed, p.reader = raw, ioutil.NopCloser(raw), raw
+			} else {
+				decompressed, _, err := Decompress(raw, p.paths[0])
+				if err != nil {
+					raw.Close()
+					return 0, err
+				}
+				p.raw, p.decompressed = raw, decompressed
+				p.reader = Documents(decompressed, p.paths[0], p.format, p.archiveNamespace)
+			}
+		}
+
+		n, err := p.reader.Read(b)
+		if err == io.EOF {
+			p.closeCurrent()
+			p.paths = p.paths[1:]
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *pathsReader) closeCurrent() error {
+	if p.reader == nil {
+		return nil
+	}
+	p.decompressed.Close()
+	err := p.raw.Close()
+	p.raw, p.decompressed, p.reader = nil, nil, nil
+	return err
+}
+
+func (p *pathsReader) Close() error {
+	return p.closeCurrent()
+}
+
+// Raw marks an opened input as plain BSON documents, so it's read as it is without checking
+// for compression or an archive. It's for files opened part way through, whose first bytes
+// aren't the ones the checks look for.
+func Raw(r io.ReadCloser) io.ReadCloser {
+	return rawInput{r}
+}
+
+type rawInput struct {
+	io.ReadCloser
+}
+
+// StreamPaths is like OpenPaths, but streams each path, reopening them if they break until ctx
+// is done
+func StreamPaths(ctx context.Context, paths []string, maxReopens int, format Format, archiveNamespace string) io.ReadCloser {
+	return OpenPaths(paths, func(path string) (io.ReadCloser, error) {
+		return NewResumableReader(ctx, path, PathOpener(path), maxReopens), nil
+	}, format, archiveNamespace)
+}
+
+// Extract returns the documents in namespace if r is a mongodump archive, or r as it is if
+// it's a plain dump
+func Extract(r io.Reader, namespace string) io.Reader {
+	buffered := bufio.NewReader(r)
+	header, _ := buffered.Peek(4)
+	if bsonScanner.IsArchive(header) {
+		return bsonScanner.NewArchiveReader(buffered, namespace)
+	}
+	return buffered
+}
+
+// KnownSize returns the total size of the paths if they're all uncompressed local BSON files
+// that aren't archives, or zero if it isn't known up front
+func KnownSize(paths []string, format Format) int64 {
+	var total int64
+	for _, path := range paths {
+		local, ok := LocalBSONFile(path, format)
+		if !ok {
+			return 0
+		}
+		fi, err := os.Stat(local)
+		if err != nil {
+			return 0
+		}
+		total += fi.Size()
+	}
+	return total
+}
+
+// LocalBSONFile returns the local file of path if it's an uncompressed BSON file that isn't
+// an archive, which can be read from any offset
+func LocalBSONFile(path string, format Format) (string, bool) {
+	if !isLocal(path) || FormatOf(path, format) != FormatBSON {
+		return "", false
+	}
+	local := strings.TrimPrefix(path, "file://")
+	if compression, err := DetectFile(local); err != nil || compression != None || isArchiveFile(local) {
+		return "", false
+	}
+	return local, true
+}
+
+func isArchiveFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bsonScanner.IsArchive(header)
+}
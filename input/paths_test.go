@@ -0,0 +1,97 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingCloser counts how many of the opened files have been closed
+type countingCloser struct {
+	io.ReadCloser
+	closed *int
+}
+
+func (c countingCloser) Close() error {
+	*c.closed++
+	return c.ReadCloser.Close()
+}
+
+func compressBytes(t *testing.T, compression Compression, data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch compression {
+	case Gzip:
+		w = gzip.NewWriter(buffer)
+	case Zstd:
+		var err error
+		w, err = zstd.NewWriter(buffer)
+		assert.NoError(t, err)
+	case Snappy:
+		w = s2.NewWriter(buffer)
+	}
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buffer.Bytes()
+}
+
+func TestOpenPathsMixedCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first := oplog(t, timestamp(100, 1), timestamp(100, 2))
+	second := oplog(t, timestamp(200, 1))
+	third := oplog(t, timestamp(300, 1), timestamp(300, 2), timestamp(300, 3))
+	fourth := oplog(t, timestamp(400, 1))
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"1.bson", first},
+		{"2.bson.gz", compressBytes(t, Gzip, second)},
+		// No extension, so it's detected from the magic bytes
+		{"3", compressBytes(t, Zstd, third)},
+		{"4.s2", compressBytes(t, Snappy, fourth)},
+		{"5.bz2", bzip2Data},
+	}
+	paths := []string{}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		assert.NoError(t, ioutil.WriteFile(path, file.data, 0644))
+		paths = append(paths, path)
+	}
+
+	closed := 0
+	opened := []string{}
+	r := OpenPaths(paths, func(path string) (io.ReadCloser, error) {
+		opened = append(opened, filepath.Base(path))
+		f, err := os.Open(path)
+		return countingCloser{f, &closed}, err
+	}, FormatAuto, "")
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	expected := bytes.Join([][]byte{first, second, third, fourth, []byte(testData)}, nil)
+	assert.Equal(t, expected, data)
+	assert.Equal(t, []string{"1.bson", "2.bson.gz", "3", "4.s2", "5.bz2"}, opened)
+	assert.Equal(t, len(files), closed)
+}
+
+func TestOpenPathsError(t *testing.T) {
+	r := OpenPaths([]string{"missing.bson"}, func(path string) (io.ReadCloser, error) {
+		return os.Open(path)
+	}, FormatAuto, "")
+	_, err := ioutil.ReadAll(r)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Clever/pathio"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultMaxReopens is how many times in a row a broken stream is reopened before giving up
+const DefaultMaxReopens = 5
+
+// Opener opens a source so that the first byte read is the one at offset
+type Opener func(offset int64) (io.ReadCloser, error)
+
+// isLocal is whether pathio would read the path from the local filesystem
+func isLocal(path string) bool {
+	return !strings.Contains(path, "://") || strings.HasPrefix(path, "file://")
+}
+
+// PathOpener opens any path pathio understands. Local files seek straight to the offset and S3
+// objects are read from it with a ranged GET. pathio can't read a range of any other sources,
+// so those are reopened from the start and read up to the offset, which costs bandwidth but no
+// disk or memory.
+func PathOpener(path string) Opener {
+	var client *s3.S3
+	return func(offset int64) (io.ReadCloser, error) {
+		if isLocal(path) {
+			f, err := os.Open(strings.TrimPrefix(path, "file://"))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, err
+			}
+			return f, nil
+		}
+		if bucket, key, ok := parseS3Path(path); ok && offset > 0 {
+			if client == nil {
+				var err error
+				if client, err = newS3Client(bucket); err != nil {
+					return nil, err
+				}
+			}
+			return openS3Range(client, bucket, key, offset)
+		}
+
+		r, err := pathio.Reader(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("Error skipping to offset %d %s", offset, err)
+		}
+		return r, nil
+	}
+}
+
+// ResumableReader reads a source as a stream and, if the stream breaks, reopens it at the
+// last byte it read and carries on. This gives the reliability of downloading the whole
+// source first without waiting for the download or using the disk space.
+type ResumableReader struct {
+	open       Opener
+	name       string
+	maxReopens int
+	current    io.ReadCloser
+	offset     int64
+	// failures is the number of reopens since data was last read successfully
+	failures int
+	done     bool
+	ctx      context.Context
+	sleep    func(ctx context.Context, wait time.Duration) error
+}
+
+// NewResumableReader returns a reader for the source that open opens. name is only used in logs.
+// When ctx is done it stops waiting to reopen the source and returns ctx's error.
+func NewResumableReader(ctx context.Context, name string, open Opener, maxReopens int) *ResumableReader {
+	return &ResumableReader{open: open, name: name, maxReopens: maxReopens, ctx: ctx, sleep: sleep}
+}
+
+// sleep waits for the duration, or until ctx is done
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Read implements io.Reader
+func (r *ResumableReader) Read(p []byte) (int, error) {
+	for {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.current == nil {
+			current, err := r.open(r.offset)
+			if err != nil {
+				if retryErr := r.retry(err); retryErr != nil {
+					return 0, retryErr
+				}
+				continue
+			}
+			r.current = current
+		}
+
+		n, err := r.current.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == io.EOF {
+			r.done = true
+			r.Close()
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		// The stream broke. Return what was read and reopen on the next call.
+		r.current.Close()
+		r.current = nil
+		if retryErr := r.retry(err); retryErr != nil {
+			return n, retryErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// retry records a failure and waits before the source is reopened. It returns an error if
+// there have been too many failures in a row or ctx is done.
+func (r *ResumableReader) retry(err error) error {
+	r.failures++
+	if r.failures > r.maxReopens {
+		return fmt.Errorf("Error reading %s at offset %d after %d reopens %s", r.name, r.offset, r.maxReopens, err)
+	}
+	wait := time.Duration(1<<uint(r.failures-1)) * time.Second
+	log.Printf("Error reading %s at offset %d, reopening in %s (%d of %d): %s",
+		r.name, r.offset, wait, r.failures, r.maxReopens, err)
+	return r.sleep(r.ctx, wait)
+}
+
+// Offset returns the number of bytes read so far
+func (r *ResumableReader) Offset() int64 {
+	return r.offset
+}
+
+// Close closes the underlying stream, if it's open
+func (r *ResumableReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
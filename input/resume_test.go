@@ -0,0 +1,116 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// breakingReader returns an error after reading limit bytes
+type breakingReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (b *breakingReader) Read(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(p) > b.limit {
+		p = p[:b.limit]
+	}
+	n, err := b.r.Read(p)
+	b.limit -= n
+	return n, err
+}
+
+func (b *breakingReader) Close() error {
+	return nil
+}
+
+func TestResumableReader(t *testing.T) {
+	data := "the quick brown fox jumps over the lazy dog"
+	offsets := []int64{}
+	open := func(offset int64) (io.ReadCloser, error) {
+		offsets = append(offsets, offset)
+		// The first two opens fail part way, the third can't open at all
+		switch len(offsets) {
+		case 1, 2:
+			return &breakingReader{r: strings.NewReader(data[offset:]), limit: 10}, nil
+		case 3:
+			return nil, errors.New("service unavailable")
+		}
+		return ioutil.NopCloser(strings.NewReader(data[offset:])), nil
+	}
+
+	r := NewResumableReader(context.Background(), "test", open, 3)
+	r.sleep = func(context.Context, time.Duration) error { return nil }
+	read, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(read))
+	assert.Equal(t, []int64{0, 10, 20, 20}, offsets)
+	assert.Equal(t, int64(len(data)), r.Offset())
+}
+
+func TestResumableReaderGivesUp(t *testing.T) {
+	open := func(offset int64) (io.ReadCloser, error) {
+		return &breakingReader{r: strings.NewReader("abc"), limit: 0}, nil
+	}
+	r := NewResumableReader(context.Background(), "test", open, 2)
+	waits := []time.Duration{}
+	r.sleep = func(ctx context.Context, wait time.Duration) error {
+		waits = append(waits, wait)
+		return nil
+	}
+	_, err := ioutil.ReadAll(r)
+	assert.Error(t, err)
+	assert.Equal(t, "Error reading test at offset 0 after 2 reopens connection reset by peer", err.Error())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+}
+
+func TestResumableReaderStopsWaitingWhenCancelled(t *testing.T) {
+	open := func(offset int64) (io.ReadCloser, error) {
+		return nil, errors.New("service unavailable")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := NewResumableReader(ctx, "test", open, DefaultMaxReopens)
+	start := time.Now()
+	_, err := ioutil.ReadAll(r)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Now().Sub(start) < time.Second)
+}
+
+func TestParseS3Path(t *testing.T) {
+	bucket, key, ok := parseS3Path("s3://bucket/dir/oplog.bson")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "dir/oplog.bson", key)
+	for _, path := range []string{"/tmp/oplog.bson", "s3://bucket", "s3://bucket/", "file:///tmp/oplog.bson"} {
+		_, _, ok := parseS3Path(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestPathOpener(t *testing.T) {
+	f, err := ioutil.TempFile("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(f.Name())
+	_, err = f.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	f.Close()
+
+	r, err := PathOpener(f.Name())(4)
+	assert.NoError(t, err)
+	defer r.Close()
+	read, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "456789", string(read))
+}
@@ -0,0 +1,57 @@
+package input
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// parseS3Path splits an s3://bucket/key path into its bucket and key
+func parseS3Path(path string) (string, string, bool) {
+	if !strings.HasPrefix(path, "s3://") {
+		return "", "", false
+	}
+	split := strings.SplitN(strings.TrimPrefix(path, "s3://"), "/", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", false
+	}
+	return split[0], split[1], true
+}
+
+// newS3Client returns a client for the region the bucket is in, like pathio uses
+func newS3Client(bucket string) (*s3.S3, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating AWS session %s", err)
+	}
+	location, err := s3.New(sess, aws.NewConfig().WithRegion("us-east-1")).GetBucketLocation(
+		&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return nil, fmt.Errorf("Error finding the region of bucket %s %s", bucket, err)
+	}
+	region := s3.NormalizeBucketLocation(aws.StringValue(location.LocationConstraint))
+	return s3.New(sess, aws.NewConfig().WithRegion(region)), nil
+}
+
+// openS3Range opens an S3 object so that the first byte read is the one at offset, with a
+// ranged GET so the bytes before it aren't downloaded again
+func openS3Range(client *s3.S3, bucket, key string, offset int64) (io.ReadCloser, error) {
+	out, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		// The offset is the end of the object, so there's nothing left to read
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading s3://%s/%s from offset %d %s", bucket, key, offset, err)
+	}
+	return out.Body, nil
+}
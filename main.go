@@ -1,44 +1,669 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Clever/mongo-op-throttler/apply"
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/config"
+	"github.com/Clever/mongo-op-throttler/convert"
+	"github.com/Clever/mongo-op-throttler/deadletter"
+	"github.com/Clever/mongo-op-throttler/index"
+	"github.com/Clever/mongo-op-throttler/input"
+	"github.com/Clever/mongo-op-throttler/metrics"
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/Clever/mongo-op-throttler/stats"
+	"github.com/Clever/mongo-op-throttler/tail"
+	"github.com/Clever/mongo-op-throttler/target"
+	"github.com/Clever/mongo-op-throttler/transform"
 	"github.com/Clever/pathio"
-
-	"gopkg.in/mgo.v2"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// exitInterrupted is the exit code when a replay is stopped by a signal after cleanly finishing
+// the operation it was applying
+const exitInterrupted = 3
+
 func main() {
-	mongoURL := flag.String("mongoURL", "localhost", "The mongo database to run the operations against")
-	path := flag.String("path", "", "The path to the json operations to replay")
-	opsPerSecond := flag.Float64("speed", 1, "The number of operations to apply per second")
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		runStats(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		runRecord(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		runConvert(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		runIndex(os.Args[2:])
+		return
+	}
+
+	os.Exit(run())
+}
+
+// run replays the input and returns the exit code. It returns rather than exiting so that the
+// deferred cleanup, like removing temp files and closing the dead letter file, always runs.
+func run() int {
+	configPath := flag.String("config", "", "Optional YAML or JSON file configuring the replay. Flags override values in it")
+	flag.String("mongoURL", "localhost", "The mongo database to run the operations against")
+	flag.String("path", "", "The path to the json operations to replay. Several paths, directories or globs can be given separated by commas, and are merged in timestamp order")
+	flag.Duration("max-gap", 0, "Warn when there's a gap longer than this between one input's last entry and the next input's first. 0 only checks for overlaps")
+	flag.Bool("stream", false, "Read the input directly instead of downloading it to a temporary file first, reopening it if the stream breaks")
+	flag.String("input-format", string(input.FormatAuto), "The format of the input: bson, extjson for MongoDB Extended JSON lines, or auto to pick by extension, reading .json, .jsonl and .ndjson files as extjson")
+	flag.String("tail", "", "Replay the oplog of this replica set member as it's written instead of reading --path")
+	flag.Bool("change-stream", false, "With --tail, read a change stream of every database instead of the oplog. Works on sharded clusters through mongos")
+	tailConnectionFlags(flag.CommandLine)
+	flag.String("tail-from", "", "With --tail, start after this oplog ts, as seconds, seconds:increment or an RFC 3339 time. Defaults to the end of the oplog")
+	flag.String("checkpoint", "", "With --tail, save the ts of the last replayed entry to this file and resume after it when restarted")
+	flag.Duration("checkpoint-interval", tail.DefaultCheckpointInterval, "How often to save the --checkpoint")
+	flag.String("from-ts", "", "Start the replay after this oplog ts, as seconds, seconds:increment or an RFC 3339 time. Local BSON files seek to it using their index")
+	flag.String("until-ts", "", "Stop the replay at the first entry after this oplog ts")
+	flag.Bool("save-index", false, "With --from-ts, save the index built for local BSON files next to them to reuse next time. Ignored with --dry-run")
+	flag.Bool("recover", false, "Skip over corrupt parts of the input to the next valid entry instead of stopping, logging each part skipped")
+	flag.Bool("ops", false, "The input is ops files written by the convert subcommand instead of oplogs")
+	flag.String("archive-namespace", bsonScanner.ArchiveOplog, "The namespace to replay from inputs that are mongodump --archive files. The oplog from --oplog is \"oplog\"")
+	flag.Float64("speed", 1, "The number of operations to apply per second")
+	flag.String("transform", "", "Optional path to a JavaScript file defining a transform(op) function to run on each operation")
+	flag.Bool("dry-run", false, "Convert and validate every operation without connecting to Mongo")
+	flag.Duration("progress-interval", apply.DefaultProgressInterval, "How often to log progress")
+	flag.String("metrics-addr", "", "If set, serve Prometheus metrics at /metrics on this address, for example :9090")
+	flag.Bool("continue-on-error", false, "Keep replaying when an entry fails instead of stopping")
+	flag.String("dead-letter", "", "File to record failed entries in when --continue-on-error is set. Written as JSON lines if it ends in .json or .jsonl, otherwise as BSON")
+	flag.Int("max-errors", 0, "Stop after this many failures when --continue-on-error is set. 0 means no limit")
+	flag.Int("max-retries", apply.DefaultRetryPolicy.MaxRetries, "How many times to retry an operation that fails with a transient error, like during an election")
+	flag.String("driver", "mgo", "The Mongo client to apply operations with, mgo or mongo-driver. Use mongo-driver for Mongo 4.4 and later")
+	flag.String("write-concern", "", "Write concern w, a number of members or a tag like majority. Defaults to the server's")
+	flag.Bool("journal", false, "Wait for writes to be written to the journal")
+	flag.Duration("wtimeout", 0, "How long to wait for the write concern before failing an operation. 0 waits forever")
+	flag.String("read-preference", "", "Read preference: primary, primaryPreferred, secondary, secondaryPreferred or nearest")
+	flag.Bool("tls", false, "Connect to Mongo over TLS. Implied by any of the --tls-*-file flags")
+	flag.String("tls-ca-file", "", "PEM bundle of certificate authorities to trust instead of the system's")
+	flag.String("tls-cert-file", "", "PEM client certificate to present to Mongo")
+	flag.String("tls-key-file", "", "PEM key for --tls-cert-file")
+	flag.String("auth-source", "", "Database to authenticate against. Defaults to the one in --mongoURL, or admin")
+	flag.String("auth-mechanism", "", "Auth mechanism, for example SCRAM-SHA-1, SCRAM-SHA-256 or MONGODB-X509")
+	flag.String("username", "", "Username to authenticate with. Defaults to $MONGO_USERNAME")
+	flag.String("password-file", "", "File containing the password to authenticate with. Defaults to reading $MONGO_PASSWORD")
 	flag.Parse()
 
-	session, err := mgo.Dial(*mongoURL)
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath); err != nil {
+			log.Printf("Error loading config %s", err)
+			return 1
+		}
+	}
+	var overrideErr error
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || overrideErr != nil {
+			return
+		}
+		overrideErr = cfg.Override(f.Name, f.Value.String())
+	})
+	if overrideErr != nil {
+		log.Printf("%s", overrideErr)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Printf("%s", err)
+		return 1
+	}
+
+	if cfg.MetricsAddr != "" {
+		metrics.Serve(cfg.MetricsAddr)
+	}
+
+	opts := apply.Options{
+		OpsPerSecond:     cfg.Rate.OpsPerSecond,
+		ProgressInterval: cfg.ProgressInterval,
+		Filters:          cfg.ApplyFilters(),
+	}
+	if cfg.Input.Ops {
+		opts.Decode = operation.Unmarshal
+	}
+	transformers := []transform.Transformer{}
+	if remap := cfg.Remapper(); remap != nil {
+		transformers = append(transformers, remap)
+	}
+	if cfg.Transform != "" {
+		script, err := scriptFromPath(cfg.Transform)
+		if err != nil {
+			log.Printf("Error loading transform script %s", err)
+			return 1
+		}
+		transformers = append(transformers, script)
+	}
+	if len(transformers) == 1 {
+		opts.Transformer = transformers[0]
+	} else if len(transformers) > 1 {
+		opts.Transformer = transform.Chain(transformers...)
+	}
+
+	opts.Retry = apply.DefaultRetryPolicy
+	opts.Retry.MaxRetries = cfg.Errors.MaxRetries
+	if cfg.Errors.Continue {
+		var deadLetter *deadletter.Writer
+		if cfg.Errors.DeadLetter != "" {
+			deadLetterFile, err := os.Create(cfg.Errors.DeadLetter)
+			if err != nil {
+				log.Printf("Error creating dead letter file %s", err)
+				return 1
+			}
+			defer deadLetterFile.Close()
+			deadLetter = deadletter.NewWriter(deadLetterFile, deadletter.FormatFromPath(cfg.Errors.DeadLetter))
+		}
+		opts.OnError = apply.ContinueOnError(deadLetter, cfg.Errors.MaxErrors)
+	}
+
+	// Connect before downloading the input so a bad URL or credentials fail fast
+	if !cfg.DryRun {
+		dialOpts, err := cfg.DialOptions()
+		if err != nil {
+			log.Printf("%s", err)
+			return 1
+		}
+		sessionOpts := cfg.SessionOptions()
+		if cfg.Target.Driver == "mongo-driver" {
+			d, err := target.DialDriver(dialOpts, sessionOpts)
+			if err != nil {
+				log.Printf("Failed to connect to Mongo %s", err)
+				return 1
+			}
+			defer d.Close()
+			opts.Target = d
+		} else {
+			session, err := target.DialMgo(dialOpts)
+			if err != nil {
+				log.Printf("Failed to connect to Mongo %s", err)
+				return 1
+			}
+			defer session.Close()
+			if err := sessionOpts.Apply(session); err != nil {
+				log.Printf("Error configuring session %s", err)
+				return 1
+			}
+			opts.Session = session
+		}
+		opts.Description = sessionOpts.String()
+	}
+
+	ctx := cancelOnSignal()
+	var checkpoint *tail.Checkpoint
+	if cfg.Input.Tail.URL != "" {
+		var source io.ReadCloser
+		var err error
+		if source, checkpoint, err = openTail(ctx, cfg.Input.Tail); err != nil {
+			log.Printf("%s", err)
+			return 1
+		}
+		defer source.Close()
+		opts.Input = source
+		if cfg.Input.Tail.ChangeStream {
+			opts.Decode = convert.ChangeEventBytesToOp
+		}
+		if checkpoint != nil {
+			opts.Checkpoint = checkpoint.Update
+		}
+	} else {
+		paths, err := input.ExpandPaths(cfg.Input.Paths)
+		if err != nil {
+			log.Printf("Error finding input files %s", err)
+			return 1
+		}
+		format := input.Format(cfg.Input.Format)
+		var from, until bson.MongoTimestamp
+		if cfg.Input.FromTS != "" {
+			// They've already been validated
+			from, _ = tail.ParseTimestamp(cfg.Input.FromTS)
+		}
+		if cfg.Input.UntilTS != "" {
+			until, _ = tail.ParseTimestamp(cfg.Input.UntilTS)
+		}
+		var merger *input.Merger
+		if cfg.Input.Stream {
+			open := func(path string) (io.ReadCloser, error) {
+				return input.NewResumableReader(ctx, path, input.PathOpener(path), input.DefaultMaxReopens), nil
+			}
+			if from != 0 {
+				open = seekFrom(open, from, format, cfg.Input.SaveIndex && !cfg.DryRun)
+			}
+			merger = input.Merge(paths, open, format, cfg.Input.ArchiveNamespace)
+			opts.TotalBytes = input.KnownSize(paths, format)
+		} else {
+			// Download everything before starting so a broken download doesn't stop the replay part way
+			filenames := map[string]string{}
+			tempPaths := []string{}
+			for _, path := range paths {
+				if _, ok := input.LocalBSONFile(path, format); ok && from != 0 {
+					// Read in place from the indexed offset instead of copying the whole file
+					continue
+				}
+				filename, err := tempFileFromPath(path)
+				if err != nil {
+					log.Printf("Error creating temp file from path %s", err)
+					return 1
+				}
+				defer os.RemoveAll(filename)
+				filenames[path] = filename
+				tempPaths = append(tempPaths, filename)
+			}
+			open := func(path string) (io.ReadCloser, error) {
+				return os.Open(filenames[path])
+			}
+			if from != 0 {
+				open = seekFrom(open, from, format, cfg.Input.SaveIndex && !cfg.DryRun)
+			}
+			merger = input.Merge(paths, open, format, cfg.Input.ArchiveNamespace)
+			opts.TotalBytes = input.KnownSize(tempPaths, format)
+			for _, path := range paths {
+				// The temp files lose the extension that marks them as Extended JSON
+				if input.FormatOf(path, format) != input.FormatBSON {
+					opts.TotalBytes = 0
+				}
+			}
+		}
+		if cfg.Input.Recover {
+			merger.Recover(func(path string, skip bsonScanner.Skip) {
+				log.Printf("Skipped %d corrupt bytes at offset %d of %s: %s", skip.Length, skip.Offset, path, skip.Reason)
+			})
+		}
+		defer merger.Close()
+		opts.Input = merger
+		if from != 0 || until != 0 {
+			opts.Input = input.Between(merger, from, until)
+			// Only part of the input is replayed, so its size doesn't say how far along it is
+			opts.TotalBytes = 0
+		}
+		// Warn about inputs that don't line up once they've all been read
+		defer func() {
+			for _, problem := range input.CheckRanges(merger.Ranges(), cfg.Input.MaxGap) {
+				log.Printf("Warning: %s", problem)
+			}
+		}()
+	}
+
+	if cfg.DryRun {
+		report, err := apply.DryRun(opts)
+		report.Print(os.Stdout)
+		if err != nil {
+			log.Printf("Error reading ops %s", err)
+			return 1
+		}
+		if len(report.Errors) > 0 {
+			log.Printf("Found %d invalid oplog entries", len(report.Errors))
+			return 1
+		}
+		return 0
+	}
+
+	result, err := apply.Run(ctx, opts)
+	if checkpoint != nil {
+		if err := checkpoint.Flush(); err != nil {
+			log.Printf("%s", err)
+		}
+	}
+	if err == apply.ErrInterrupted {
+		log.Printf("Stopped cleanly after a signal")
+		if checkpoint == nil && result.LastTimestamp != 0 {
+			log.Printf("Resume with --from-ts %s", tail.FormatTimestamp(result.LastTimestamp))
+		}
+		return exitInterrupted
+	}
+	if err != nil {
+		log.Printf("Error applying ops %s", err)
+		return 1
+	}
+	return 0
+}
+
+// openTail connects to the deployment to tail and works out where to start, which is after
+// the checkpoint if one has been saved. Closing the returned reader disconnects.
+func openTail(ctx context.Context, cfg config.Tail) (io.ReadCloser, *tail.Checkpoint, error) {
+	var from bson.MongoTimestamp
+	if cfg.From != "" {
+		// It's already been validated
+		from, _ = tail.ParseTimestamp(cfg.From)
+	}
+	var checkpoint *tail.Checkpoint
+	var saved string
+	if cfg.Checkpoint != "" {
+		if cfg.ChangeStream {
+			checkpoint = tail.NewResumeTokenCheckpoint(cfg.Checkpoint, cfg.CheckpointInterval)
+		} else {
+			checkpoint = tail.NewCheckpoint(cfg.Checkpoint, cfg.CheckpointInterval)
+		}
+		var err error
+		if saved, err = checkpoint.Load(); err != nil {
+			return nil, nil, err
+		}
+		if saved != "" {
+			log.Printf("Resuming after the checkpoint %s", saved)
+		}
+	}
+
+	dialOpts, err := cfg.DialOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ChangeStream {
+		var token driverbson.Raw
+		if saved != "" {
+			if token, err = tail.ParseResumeToken(saved); err != nil {
+				return nil, nil, fmt.Errorf("Error reading checkpoint %s", err)
+			}
+		}
+		client, err := target.DialClient(dialOpts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to connect to the source %s", err)
+		}
+		stream, err := tail.WatchChanges(ctx, client, token, from)
+		if err != nil {
+			return nil, nil, err
+		}
+		return withCleanup{stream, func() error { return client.Disconnect(context.Background()) }}, checkpoint, nil
+	}
+
+	if saved != "" {
+		if from, err = tail.ParseTimestamp(saved); err != nil {
+			return nil, nil, fmt.Errorf("Error reading checkpoint %s", err)
+		}
+	}
+	session, err := target.DialMgo(dialOpts)
 	if err != nil {
-		log.Fatalf("Failed to connect to Mongo %s", err)
+		return nil, nil, fmt.Errorf("Failed to connect to the source %s", err)
+	}
+	reader := tail.NewReader(ctx, session, from)
+	return withCleanup{reader, func() error { session.Close(); return nil }}, checkpoint, nil
+}
+
+// tailConnectionFlags adds the flags for connecting to the --tail source. They're named like
+// the target's, with a tail- prefix.
+func tailConnectionFlags(flags *flag.FlagSet) {
+	flags.Bool("tail-tls", false, "Connect to the --tail source over TLS. Implied by any of the --tail-tls-*-file flags")
+	flags.String("tail-tls-ca-file", "", "PEM bundle of certificate authorities to trust for the --tail source")
+	flags.String("tail-tls-cert-file", "", "PEM client certificate to present to the --tail source")
+	flags.String("tail-tls-key-file", "", "PEM key for --tail-tls-cert-file")
+	flags.String("tail-auth-source", "", "Database to authenticate against on the --tail source")
+	flags.String("tail-auth-mechanism", "", "Auth mechanism for the --tail source, for example SCRAM-SHA-256 or MONGODB-X509")
+	flags.String("tail-username", "", "Username to authenticate to the --tail source with")
+	flags.String("tail-password-file", "", "File containing the password to authenticate to the --tail source with")
+}
+
+// withCleanup is a reader that runs extra cleanup after it's closed
+type withCleanup struct {
+	io.ReadCloser
+	cleanup func() error
+}
+
+func (c withCleanup) Close() error {
+	err := c.ReadCloser.Close()
+	if cleanupErr := c.cleanup(); err == nil {
+		err = cleanupErr
+	}
+	return err
+}
+
+// cancelOnSignal returns a context that's canceled on the first SIGTERM or SIGINT, so the
+// replay can finish the operation it's applying and stop. A second signal exits immediately.
+func cancelOnSignal() context.Context {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, stopping after the current operation", sig)
+		cancel()
+		sig = <-signals
+		log.Fatalf("Received %s again, exiting immediately", sig)
+	}()
+	return ctx
+}
+
+// runStats implements the "stats" subcommand, which prints statistics about an oplog dump
+// to help choose a --speed before replaying it
+func runStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	path := flags.String("path", "", "The path to the oplog to inspect")
+	bucket := flags.Duration("bucket", time.Minute, "The width of each bucket in the ops per second histogram")
+	format := flags.String("input-format", string(input.FormatAuto), "The format of the input: bson, extjson or auto to pick by extension")
+	archiveNamespace := flags.String("archive-namespace", bsonScanner.ArchiveOplog, "The namespace to inspect if the input is a mongodump --archive file")
+	opsPerSecond := flags.Float64("speed", 0, "If set, estimate how long replaying the oplog would take at this many operations per second")
+	flags.Parse(args)
+
+	if *bucket < time.Second {
+		log.Fatalf("--bucket must be at least 1s")
+	}
+	if _, err := input.ParseFormat(*format); err != nil {
+		log.Fatalf("%s", err)
 	}
-	defer session.Close()
 
 	filename, err := tempFileFromPath(*path)
 	if err != nil {
 		log.Fatalf("Error creating temp file from path %s", err)
 	}
-	f, err := os.Open(filename)
+	defer os.RemoveAll(filename)
+	// Read through a Merger, which checks the documents, keeping the path for its extension
+	merger := input.Merge([]string{*path}, func(string) (io.ReadCloser, error) {
+		return os.Open(filename)
+	}, input.Format(*format), *archiveNamespace)
+	defer merger.Close()
+
+	s, err := stats.Collect(merger)
 	if err != nil {
-		log.Fatalf("Error opening file back up %s", err)
+		log.Fatalf("Error reading oplog %s", err)
 	}
-	defer os.RemoveAll(filename)
-	defer f.Close()
+	s.Print(os.Stdout, *bucket, *opsPerSecond)
+}
+
+// runConvert implements the "convert" subcommand, which converts an oplog into an ops file
+// that can be replayed with --ops
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	paths := flags.String("path", "", "The oplogs to convert. Several paths, directories or globs can be given separated by commas, and are merged in timestamp order")
+	out := flags.String("out", "", "The ops file to write. Written as Extended JSON lines if it ends in .json or .jsonl, otherwise as BSON")
+	format := flags.String("input-format", string(input.FormatAuto), "The format of the input: bson, extjson or auto to pick by extension")
+	archiveNamespace := flags.String("archive-namespace", bsonScanner.ArchiveOplog, "The namespace to convert if the input is a mongodump --archive file")
+	flags.Parse(args)
 
-	if err = apply.ApplyOps(f, *opsPerSecond, session); err != nil {
-		log.Fatalf("Error applying ops %s", err)
+	if *paths == "" || *out == "" {
+		log.Fatalf("--path and --out are required")
+	}
+	if _, err := input.ParseFormat(*format); err != nil {
+		log.Fatalf("%s", err)
+	}
+	expanded, err := input.ExpandPaths(strings.Split(*paths, ","))
+	if err != nil {
+		log.Fatalf("Error finding input files %s", err)
+	}
+	merger := input.Merge(expanded, func(path string) (io.ReadCloser, error) {
+		return input.NewResumableReader(context.Background(), path, input.PathOpener(path), input.DefaultMaxReopens), nil
+	}, input.Format(*format), *archiveNamespace)
+	defer merger.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Error creating ops file %s", err)
+	}
+	buffered := bufio.NewWriter(f)
+	summary, err := convert.WriteOps(merger, operation.NewWriter(buffered, operation.FormatFromPath(*out)))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		log.Fatalf("Error writing ops file %s", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error writing ops file %s", err)
+	}
+	log.Printf("Converted %d oplog entries to %d ops in %s, skipping %d no-ops", summary.Entries, summary.Ops, *out, summary.NoOps)
+}
+
+// runRecord implements the "record" subcommand, which tails a replica set's oplog and writes
+// the entries to a file that can be replayed later, like a dump of the oplog
+func runRecord(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	source := flags.String("tail", "", "The replica set member whose oplog to record")
+	out := flags.String("out", "", "The BSON file to write the oplog entries to")
+	from := flags.String("tail-from", "", "Start after this oplog ts, as seconds, seconds:increment or an RFC 3339 time. Defaults to the end of the oplog")
+	until := flags.String("until", "", "Stop at the first entry after this ts")
+	duration := flags.Duration("duration", 0, "Stop after recording for this long. 0 records until --until or a signal")
+	namespaces := flags.String("namespace", "", "Only record these namespaces, separated by commas. Patterns like clever.* are allowed")
+	tailConnectionFlags(flags)
+	flags.Parse(args)
+
+	if *source == "" || *out == "" {
+		log.Fatalf("--tail and --out are required")
+	}
+	opts := tail.RecordOptions{}
+	for _, ts := range []string{*from, *until} {
+		if _, err := tail.ParseTimestamp(ts); ts != "" && err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if *until != "" {
+		opts.Until, _ = tail.ParseTimestamp(*until)
+	}
+	if *namespaces != "" {
+		opts.Namespaces = strings.Split(*namespaces, ",")
+	}
+
+	ctx := cancelOnSignal()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	// The source flags are the same as a replay's, so the config can set them
+	cfg := config.Default()
+	flags.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "tail") {
+			if err := cfg.Override(f.Name, f.Value.String()); err != nil {
+				log.Fatalf("%s", err)
+			}
+		}
+	})
+	reader, _, err := openTail(ctx, cfg.Input.Tail)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	defer reader.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Error creating %s", err)
+	}
+	buffered := bufio.NewWriter(f)
+	summary, recordErr := tail.Record(reader, buffered, opts)
+	// Keep what was recorded before any error
+	if err := buffered.Flush(); err != nil {
+		log.Fatalf("Error writing %s", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error writing %s", err)
+	}
+	log.Printf("Recorded %d of %d oplog entries to %s, from %s to %s", summary.Recorded, summary.Entries, *out,
+		tail.FormatTimestamp(summary.First), tail.FormatTimestamp(summary.Last))
+	// Running out of time or getting a signal is how most recordings end
+	if recordErr != nil && recordErr != context.DeadlineExceeded && recordErr != context.Canceled {
+		log.Fatalf("Error recording the oplog %s", recordErr)
+	}
+}
+
+// seekFrom opens local BSON files at the indexed entry before from instead of at the start,
+// building their index if they don't have an up to date one, and opens other paths with open.
+// Built indexes are only saved next to the files if save is set.
+func seekFrom(open input.OpenFunc, from bson.MongoTimestamp, format input.Format, save bool) input.OpenFunc {
+	return func(path string) (io.ReadCloser, error) {
+		local, ok := input.LocalBSONFile(path, format)
+		if !ok {
+			return open(path)
+		}
+		idx, warning, err := index.Load(local, index.DefaultInterval, save)
+		if err != nil {
+			return nil, fmt.Errorf("Error indexing %s %s", path, err)
+		}
+		if warning != nil {
+			log.Printf("Warning: couldn't save the index of %s %s", path, warning)
+		}
+		f, err := os.Open(local)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening %s", err)
+		}
+		offset := idx.Seek(from)
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error seeking in %s %s", path, err)
+		}
+		log.Printf("Starting %s at offset %d", path, offset)
+		return input.Raw(f), nil
+	}
+}
+
+// runIndex implements the "index" subcommand, which builds the sidecar index of local BSON
+// oplogs that --from-ts uses to seek, and can split them into ranges to replay separately
+func runIndex(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	paths := flags.String("path", "", "The local BSON oplogs to index. Several paths, directories or globs can be given separated by commas")
+	interval := flags.Int64("interval", index.DefaultInterval, "The number of bytes between indexed entries")
+	split := flags.Int("split", 0, "Print the ts each of this many roughly equal ranges of each file starts at")
+	flags.Parse(args)
+
+	if *paths == "" {
+		log.Fatalf("--path is required")
+	}
+	if *interval <= 0 {
+		log.Fatalf("--interval must be positive")
+	}
+	expanded, err := input.ExpandPaths(strings.Split(*paths, ","))
+	if err != nil {
+		log.Fatalf("Error finding input files %s", err)
+	}
+	for _, path := range expanded {
+		local, ok := input.LocalBSONFile(path, input.FormatBSON)
+		if !ok {
+			log.Fatalf("Can't index %s, only local uncompressed BSON files can be read from an offset", path)
+		}
+		idx, err := index.BuildFile(local, *interval)
+		if err != nil {
+			log.Fatalf("Error indexing %s %s", path, err)
+		}
+		if err := idx.Save(local); err != nil {
+			log.Fatalf("%s", err)
+		}
+		log.Printf("Wrote %s with %d entries", index.SidecarPath(local), len(idx.Entries))
+		// Print the flags that replay just each range, which starts at its first entry and ends
+		// before the next range's
+		ranges := idx.Split(*split)
+		for i, rng := range ranges {
+			bounds := []string{}
+			if i > 0 {
+				bounds = append(bounds, "--from-ts "+tail.FormatTimestamp(rng.From-1))
+			}
+			if i < len(ranges)-1 {
+				bounds = append(bounds, "--until-ts "+tail.FormatTimestamp(ranges[i+1].From-1))
+			}
+			if len(bounds) == 0 {
+				bounds = append(bounds, "the whole file")
+			}
+			fmt.Printf("%s bytes %d-%d: %s\n", path, rng.Start, rng.End, strings.Join(bounds, " "))
+		}
 	}
 }
 
@@ -65,3 +690,18 @@ func tempFileFromPath(path string) (string, error) {
 	}
 	return f.Name(), nil
 }
+
+// scriptFromPath reads a transform script from an arbitrary pathio path and compiles it
+func scriptFromPath(path string) (*transform.Script, error) {
+	reader, err := pathio.Reader(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading from the path %s", err)
+	}
+	defer reader.Close()
+
+	src, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the script %s", err)
+	}
+	return transform.NewScript(string(src))
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mongo_op_throttler"
+
+// Unknown is the label value used when an op's namespace or type isn't known, for example
+// when the oplog entry couldn't be converted
+const Unknown = "unknown"
+
+var (
+	// OpsApplied counts operations successfully applied, by namespace and type
+	OpsApplied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ops_applied_total",
+		Help:      "Number of operations applied",
+	}, []string{"namespace", "type"})
+
+	// OpsSkipped counts oplog entries and operations that were intentionally not applied,
+	// either because they're no-ops or because a transform dropped them
+	OpsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ops_skipped_total",
+		Help:      "Number of operations skipped because they're no-ops or were dropped by a transform",
+	}, []string{"namespace", "type"})
+
+	// OpsFailed counts oplog entries that couldn't be converted and operations that couldn't
+	// be applied, by namespace and type
+	OpsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ops_failed_total",
+		Help:      "Number of operations that failed to convert or apply",
+	}, []string{"namespace", "type"})
+
+	// OpsRetried counts retries of operations after transient errors, by namespace and type
+	OpsRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ops_retried_total",
+		Help:      "Number of times operations were retried after transient errors",
+	}, []string{"namespace", "type"})
+
+	// ApplyLatency is how long each operation takes to apply, including retries, by namespace and type
+	ApplyLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apply_latency_seconds",
+		Help:      "Time taken to apply a single operation",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"namespace", "type"})
+
+	// TargetRate is the maximum number of operations per second the replay is configured for
+	TargetRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "target_ops_per_second",
+		Help:      "Configured maximum number of operations applied per second",
+	})
+
+	// BytesRead counts the bytes of input consumed
+	BytesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_read_total",
+		Help:      "Bytes of oplog input consumed",
+	})
+
+	// LastAppliedTimestamp is the oplog timestamp, in seconds since the epoch, of the last
+	// operation applied. Alerting on it not changing catches stalled replays.
+	LastAppliedTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_applied_oplog_timestamp_seconds",
+		Help:      "Oplog timestamp of the last operation applied, in seconds since the epoch",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(OpsApplied, OpsSkipped, OpsFailed, OpsRetried, ApplyLatency, TargetRate, BytesRead, LastAppliedTimestamp)
+}
+
+// Serve exposes the metrics at /metrics on the given address in the background. A failure to
+// serve is logged but doesn't stop the replay.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Error serving metrics on %s %s", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package operation
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// An ops file holds Ops one after another, so tools can generate workloads to replay without
+// faking oplog entries. Each Op is a document with these fields:
+//
+//   "id"   : The _id of the document, a string or the hex of an ObjectId
+//   "type" : "insert", "update" or "remove"
+//   "ns"   : The namespace, for example "clever.sections"
+//   "o"    : The document to insert, or the update to apply. Not used for removes.
+//   "ts"   : Optional timestamp of the oplog entry the op came from, used to merge files
+//
+// The documents are stored either as BSON, like mongodump, or as Extended JSON lines.
+
+// Format is the encoding of an ops file
+type Format string
+
+const (
+	// BSON writes each op as a BSON document, so the file can be read with bsondump
+	BSON Format = "bson"
+	// JSON writes each op as a line of canonical Extended JSON, which keeps every BSON type
+	JSON Format = "json"
+)
+
+// FormatFromPath guesses the format of an ops file from its extension, defaulting to BSON
+func FormatFromPath(path string) Format {
+	if strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".jsonl") {
+		return JSON
+	}
+	return BSON
+}
+
+// Marshal encodes an op as a BSON document
+func Marshal(op Op) ([]byte, error) {
+	return bson.Marshal(op)
+}
+
+// Unmarshal decodes and validates an op written by Marshal. It has the same signature as
+// convert.OplogBytesToOp so ops files can be replayed in place of oplogs.
+func Unmarshal(raw []byte) (*Op, error) {
+	var op Op
+	if err := bson.Unmarshal(raw, &op); err != nil {
+		return nil, fmt.Errorf("Error parsing op %s", err)
+	}
+	if op.ID == "" {
+		return nil, fmt.Errorf("Op is missing an id")
+	}
+	if op.Namespace == "" {
+		return nil, fmt.Errorf("Op %s is missing a namespace", op.ID)
+	}
+	switch op.Type {
+	case "insert", "update":
+		if op.Obj == nil {
+			return nil, fmt.Errorf("%s op %s is missing the o field", op.Type, op.ID)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("Unknown op type %q for op %s", op.Type, op.ID)
+	}
+	return &op, nil
+}
+
+// Writer writes ops files. It's safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// NewWriter returns a Writer that writes ops to w in the given format
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// Write appends a single op
+func (w *Writer) Write(op Op) error {
+	out, err := Marshal(op)
+	if err != nil {
+		return fmt.Errorf("Error encoding op %s", err)
+	}
+	switch w.format {
+	case JSON:
+		if out, err = driverbson.MarshalExtJSON(driverbson.Raw(out), true, false); err != nil {
+			return fmt.Errorf("Error encoding op as JSON %s", err)
+		}
+		out = append(out, '\n')
+	case BSON:
+	default:
+		return fmt.Errorf("Unknown ops file format %s", w.format)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(out); err != nil {
+		return fmt.Errorf("Error writing op %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package operation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func testOps() []Op {
+	id := bson.ObjectIdHex("5966d8b4f0d5d0a8b4f0d5d0")
+	return []Op{
+		{ID: id.Hex(), Type: "insert", Namespace: "clever.schools", Timestamp: bson.MongoTimestamp(1500000000<<32 | 1),
+			Obj: bson.M{"_id": id, "created": time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC), "count": int64(7)}},
+		{ID: id.Hex(), Type: "update", Namespace: "clever.schools", Obj: bson.M{"$set": bson.M{"count": 8}}},
+		{ID: id.Hex(), Type: "remove", Namespace: "clever.schools"},
+	}
+}
+
+func TestBSONRoundTrip(t *testing.T) {
+	for _, op := range testOps() {
+		raw, err := Marshal(op)
+		assert.NoError(t, err)
+		decoded, err := Unmarshal(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, op.Type, decoded.Type)
+		assert.Equal(t, op.ID, decoded.ID)
+		assert.Equal(t, op.Namespace, decoded.Namespace)
+		assert.Equal(t, op.Timestamp, decoded.Timestamp)
+		if op.Obj == nil {
+			assert.Nil(t, decoded.Obj)
+		}
+	}
+	raw, err := Marshal(testOps()[0])
+	assert.NoError(t, err)
+	decoded, err := Unmarshal(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), decoded.Obj["count"])
+	assert.Equal(t, bson.ObjectIdHex("5966d8b4f0d5d0a8b4f0d5d0"), decoded.Obj["_id"])
+}
+
+func TestWriteJSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	w := NewWriter(buffer, JSON)
+	for _, op := range testOps() {
+		assert.NoError(t, w.Write(op))
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[0], `"ts":{"$timestamp":{"t":1500000000,"i":1}}`)
+	assert.Contains(t, lines[0], `"count":{"$numberLong":"7"}`)
+
+	// Canonical Extended JSON converts back to exactly the same BSON
+	var raw driverbson.Raw
+	assert.NoError(t, driverbson.UnmarshalExtJSON([]byte(lines[0]), true, &raw))
+	op, err := Unmarshal(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), op.Obj["count"])
+	assert.Equal(t, time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC), op.Obj["created"].(time.Time).UTC())
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, doc := range []bson.M{
+		{"type": "insert", "ns": "clever.schools", "o": bson.M{}},
+		{"id": "a", "type": "insert", "o": bson.M{}},
+		{"id": "a", "type": "insert", "ns": "clever.schools"},
+		{"id": "a", "type": "upsert", "ns": "clever.schools", "o": bson.M{}},
+	} {
+		raw, err := bson.Marshal(doc)
+		assert.NoError(t, err)
+		_, err = Unmarshal(raw)
+		assert.Error(t, err)
+	}
+	_, err := Unmarshal([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestFormatFromPath(t *testing.T) {
+	assert.Equal(t, JSON, FormatFromPath("ops.jsonl"))
+	assert.Equal(t, BSON, FormatFromPath("ops.bson"))
+}
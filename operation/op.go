@@ -2,12 +2,15 @@ package operation
 
 import "gopkg.in/mgo.v2/bson"
 
-// Op is the definition of the mongo command to run
+// Op is the definition of the mongo command to run. The bson tags define the document each
+// op is stored as in an ops file, see Marshal.
 type Op struct {
-	ID string
+	ID string `bson:"id"`
 	// Valid types are: 'insert', 'update' or 'remove'
-	Type string
+	Type string `bson:"type"`
 	// The namespace as defined by mongo. For example, "clever.events"
-	Namespace string
-	Obj       bson.M
+	Namespace string `bson:"ns"`
+	Obj       bson.M `bson:"o,omitempty"`
+	// The timestamp of the oplog entry the op came from, if any
+	Timestamp bson.MongoTimestamp `bson:"ts,omitempty"`
 }
@@ -0,0 +1,299 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// Use custom scanner with higher length limitation
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/Clever/mongo-op-throttler/target"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// opNames are the friendlier names for the oplog "op" field
+var opNames = map[string]string{
+	"i": "insert",
+	"u": "update",
+	"d": "remove",
+	"c": "command",
+	"n": "noop",
+}
+
+// Stats describes the contents of an oplog dump
+type Stats struct {
+	// Entries is the total number of oplog entries
+	Entries int
+	// First and Last are the timestamps of the first and last entries with a timestamp
+	First, Last bson.MongoTimestamp
+	// Counts is the number of entries by namespace and then by op type
+	Counts map[string]map[string]int
+	// IDTypes is the number of entries by the type of the _id of the document they touch
+	IDTypes map[string]int
+	// sizes is the number of entries of each size in bytes. We keep a count per size instead
+	// of every size since dumps can have tens of millions of entries but there are far fewer
+	// distinct sizes.
+	sizes map[int]int
+	// perSecond is the number of entries for each second since the epoch
+	perSecond map[int64]int
+}
+
+// Collect reads every entry in the io.Reader and computes stats for them. The entries are
+// only split by size, so a raw dump should be read through an input.Merger to check them.
+func Collect(r io.Reader) (*Stats, error) {
+	s := &Stats{
+		Counts:    map[string]map[string]int{},
+		IDTypes:   map[string]int{},
+		sizes:     map[int]int{},
+		perSecond: map[int64]int{},
+	}
+
+	opScanner := bsonScanner.NewUnchecked(r)
+	for opScanner.Scan() {
+		var entry bson.M
+		if err := bson.Unmarshal(opScanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Error parsing bson at offset %d: %s", opScanner.Offset(), err.Error())
+		}
+		s.add(entry, len(opScanner.Bytes()))
+	}
+	if err := opScanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Stats) add(entry bson.M, size int) {
+	s.Entries++
+	s.sizes[size]++
+
+	if ts, ok := entry["ts"].(bson.MongoTimestamp); ok {
+		if s.First == 0 || ts < s.First {
+			s.First = ts
+		}
+		if ts > s.Last {
+			s.Last = ts
+		}
+		s.perSecond[seconds(ts)]++
+	}
+
+	namespace, _ := entry["ns"].(string)
+	op, _ := entry["op"].(string)
+	if name, ok := opNames[op]; ok {
+		op = name
+	}
+	if s.Counts[namespace] == nil {
+		s.Counts[namespace] = map[string]int{}
+	}
+	s.Counts[namespace][op]++
+
+	// Updates keep the _id in o2, everything else keeps it in o
+	obj, _ := entry["o"].(bson.M)
+	if op == "update" {
+		obj, _ = entry["o2"].(bson.M)
+	}
+	id, ok := obj["_id"]
+	if !ok {
+		s.IDTypes["none"]++
+		return
+	}
+	s.IDTypes[idType(id)]++
+}
+
+// seconds returns the number of seconds since the epoch for an oplog timestamp. The top
+// 32 bits of a timestamp are the seconds and the bottom 32 are an ordinal within the second.
+func seconds(ts bson.MongoTimestamp) int64 {
+	return int64(ts) >> 32
+}
+
+func idType(id interface{}) string {
+	switch id.(type) {
+	case bson.ObjectId:
+		return "objectId"
+	case string:
+		return "string"
+	case int, int64:
+		return "int"
+	case float64:
+		return "double"
+	case bson.Binary:
+		return "binary"
+	case bson.M:
+		return "document"
+	case time.Time:
+		return "date"
+	default:
+		return fmt.Sprintf("%T", id)
+	}
+}
+
+// Duration is the time between the first and last entries
+func (s *Stats) Duration() time.Duration {
+	return time.Duration(seconds(s.Last)-seconds(s.First)) * time.Second
+}
+
+// SizePercentile returns the size in bytes that p percent of entries are at or below
+func (s *Stats) SizePercentile(p float64) int {
+	sizes := []int{}
+	for size := range s.sizes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	target := int(float64(s.Entries)*p/100 + 0.5)
+	seen := 0
+	for _, size := range sizes {
+		seen += s.sizes[size]
+		if seen >= target {
+			return size
+		}
+	}
+	if len(sizes) == 0 {
+		return 0
+	}
+	return sizes[len(sizes)-1]
+}
+
+// Applicable is roughly the number of entries that ApplyOps would apply. It doesn't account
+// for entries that convert skips or fails on, which --dry-run reports.
+func (s *Stats) Applicable() int {
+	total := 0
+	for namespace, counts := range s.Counts {
+		// System collections like db.system.indexes aren't replayed
+		if _, collection, err := target.SplitNamespace(namespace); err == nil && strings.HasPrefix(collection, "system.") {
+			continue
+		}
+		total += counts["insert"] + counts["update"] + counts["remove"]
+	}
+	return total
+}
+
+// Bucket is the activity during one slice of the oplog's time range
+type Bucket struct {
+	Start time.Time
+	// Entries is the total number of entries in the bucket
+	Entries int
+	// PeakPerSecond is the highest number of entries in any single second of the bucket
+	PeakPerSecond int
+}
+
+// maxEmptyBuckets is the longest run of empty buckets kept between two busy ones. Longer gaps,
+// like one stray entry years before the rest, are left out so the histogram stays small.
+const maxEmptyBuckets = 60
+
+// Buckets splits the oplog's time range into buckets of the given width. Only buckets with
+// entries, and short runs of empty buckets between them, are returned.
+func (s *Stats) Buckets(width time.Duration) []Bucket {
+	if s.Entries == 0 || len(s.perSecond) == 0 {
+		return nil
+	}
+	widthSeconds := int64(width / time.Second)
+	if widthSeconds < 1 {
+		widthSeconds = 1
+	}
+	busy := map[int64]*Bucket{}
+	for second, count := range s.perSecond {
+		start := second - second%widthSeconds
+		b, ok := busy[start]
+		if !ok {
+			b = &Bucket{Start: time.Unix(start, 0).UTC()}
+			busy[start] = b
+		}
+		b.Entries += count
+		if count > b.PeakPerSecond {
+			b.PeakPerSecond = count
+		}
+	}
+	starts := []int64{}
+	for start := range busy {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	buckets := []Bucket{}
+	for i, start := range starts {
+		if i > 0 && (start-starts[i-1])/widthSeconds-1 <= maxEmptyBuckets {
+			for empty := starts[i-1] + widthSeconds; empty < start; empty += widthSeconds {
+				buckets = append(buckets, Bucket{Start: time.Unix(empty, 0).UTC()})
+			}
+		}
+		buckets = append(buckets, *busy[start])
+	}
+	return buckets
+}
+
+// Print writes a human readable version of the stats. The histogram groups entries into
+// buckets of the given width. If opsPerSecond is positive it also estimates how long a
+// replay would take at that speed.
+func (s *Stats) Print(w io.Writer, width time.Duration, opsPerSecond float64) {
+	fmt.Fprintf(w, "Entries: %d\n", s.Entries)
+	if s.First != 0 {
+		fmt.Fprintf(w, "First ts: %s (%d)\n", time.Unix(seconds(s.First), 0).UTC().Format(time.RFC3339), s.First)
+		fmt.Fprintf(w, "Last ts:  %s (%d)\n", time.Unix(seconds(s.Last), 0).UTC().Format(time.RFC3339), s.Last)
+		fmt.Fprintf(w, "Span: %s\n", s.Duration())
+	}
+	fmt.Fprintf(w, "Applicable ops: %d\n", s.Applicable())
+	if opsPerSecond > 0 {
+		estimate := time.Duration(float64(s.Applicable()) / opsPerSecond * float64(time.Second))
+		fmt.Fprintf(w, "Estimated replay time at %g ops/sec: %s\n", opsPerSecond, estimate)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nnamespace\tinsert\tupdate\tremove\tcommand\tnoop")
+	for _, namespace := range sortedKeys(s.Counts) {
+		counts := s.Counts[namespace]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", namespace,
+			counts["insert"], counts["update"], counts["remove"], counts["command"], counts["noop"])
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nEntry size (bytes): p50 %d, p90 %d, p99 %d, max %d\n",
+		s.SizePercentile(50), s.SizePercentile(90), s.SizePercentile(99), s.SizePercentile(100))
+
+	fmt.Fprintln(w, "\n_id types:")
+	idTypes := []string{}
+	for idType := range s.IDTypes {
+		idTypes = append(idTypes, idType)
+	}
+	sort.Strings(idTypes)
+	for _, idType := range idTypes {
+		fmt.Fprintf(w, "  %s: %d\n", idType, s.IDTypes[idType])
+	}
+
+	buckets := s.Buckets(width)
+	if len(buckets) == 0 {
+		return
+	}
+	peak := 0.0
+	for _, b := range buckets {
+		if rate := float64(b.Entries) / width.Seconds(); rate > peak {
+			peak = rate
+		}
+	}
+	fmt.Fprintf(w, "\nOps per second (%s buckets):\n", width)
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "start\tavg\tpeak\t")
+	for i, b := range buckets {
+		if i > 0 && b.Start.Sub(buckets[i-1].Start) > width {
+			fmt.Fprintf(tw, "...\t\t\t%s with no entries\n", b.Start.Sub(buckets[i-1].Start)-width)
+		}
+		rate := float64(b.Entries) / width.Seconds()
+		bar := ""
+		if peak > 0 {
+			bar = strings.Repeat("#", int(rate/peak*40+0.5))
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%d\t%s\n", b.Start.Format(time.RFC3339), rate, b.PeakPerSecond, bar)
+	}
+	tw.Flush()
+}
+
+func sortedKeys(m map[string]map[string]int) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
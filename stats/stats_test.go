@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCollect(t *testing.T) {
+	f, err := os.Open("../bson/testdata.bson")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	s, err := Collect(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, s.Entries)
+	assert.Equal(t, bson.MongoTimestamp(6021954198109683713), s.First)
+	assert.Equal(t, bson.MongoTimestamp(6021954451512754177), s.Last)
+	assert.Equal(t, 59*time.Second, s.Duration())
+	assert.Equal(t, map[string]map[string]int{
+		"testdb.$cmd": {"command": 1},
+		"testdb.test": {"insert": 3, "update": 1, "remove": 1},
+	}, s.Counts)
+	assert.Equal(t, map[string]int{"objectId": 5, "none": 1}, s.IDTypes)
+	assert.Equal(t, 5, s.Applicable())
+
+	buffer := bytes.NewBufferString("")
+	s.Print(buffer, 10*time.Second, 1)
+	assert.True(t, strings.Contains(buffer.String(), "Estimated replay time at 1 ops/sec: 5s"))
+}
+
+func TestApplicableSkipsSystemCollections(t *testing.T) {
+	s := &Stats{Counts: map[string]map[string]int{
+		"testdb.test":           {"insert": 2, "update": 1},
+		"testdb.system.indexes": {"insert": 4},
+	}}
+	assert.Equal(t, 3, s.Applicable())
+}
+
+func TestSizePercentile(t *testing.T) {
+	s := &Stats{sizes: map[int]int{}}
+	for size := 1; size <= 100; size++ {
+		s.sizes[size]++
+		s.Entries++
+	}
+	assert.Equal(t, 50, s.SizePercentile(50))
+	assert.Equal(t, 99, s.SizePercentile(99))
+	assert.Equal(t, 100, s.SizePercentile(100))
+}
+
+func TestBuckets(t *testing.T) {
+	s := &Stats{perSecond: map[int64]int{}}
+	for _, second := range []int64{60, 61, 61, 61, 185} {
+		ts := bson.MongoTimestamp(second << 32)
+		if s.First == 0 {
+			s.First = ts
+		}
+		s.Last = ts
+		s.perSecond[second]++
+		s.Entries++
+	}
+
+	buckets := s.Buckets(time.Minute)
+	assert.Equal(t, 3, len(buckets))
+	assert.Equal(t, time.Unix(60, 0).UTC(), buckets[0].Start)
+	assert.Equal(t, 4, buckets[0].Entries)
+	assert.Equal(t, 3, buckets[0].PeakPerSecond)
+	assert.Equal(t, 0, buckets[1].Entries)
+	assert.Equal(t, 1, buckets[2].Entries)
+}
+
+func TestBucketsLongGap(t *testing.T) {
+	// A stray entry decades before the rest doesn't make millions of empty buckets
+	s := &Stats{perSecond: map[int64]int{}}
+	for _, second := range []int64{60, 1500000000, 1500000001, 1500000300} {
+		s.perSecond[second]++
+		s.Entries++
+	}
+	s.First, s.Last = bson.MongoTimestamp(60<<32), bson.MongoTimestamp(1500000300<<32)
+
+	buckets := s.Buckets(time.Minute)
+	assert.Equal(t, 7, len(buckets))
+	assert.Equal(t, time.Unix(60, 0).UTC(), buckets[0].Start)
+	assert.Equal(t, time.Unix(1500000000, 0).UTC(), buckets[1].Start)
+	assert.Equal(t, 2, buckets[1].Entries)
+	assert.Equal(t, 0, buckets[2].Entries)
+	assert.Equal(t, 1, buckets[6].Entries)
+
+	out := &bytes.Buffer{}
+	s.Print(out, time.Minute, 0)
+	assert.Contains(t, out.String(), "with no entries")
+}
@@ -0,0 +1,100 @@
+package tail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ChangeStream reads a change stream of every database in a deployment, returning each change
+// event as BSON so it can be the input to apply.Run with convert.ChangeEventBytesToOp. Unlike
+// the oplog, change streams work on sharded clusters through mongos. Reads block until there's
+// a new event and return the context's error once the context is done.
+type ChangeStream struct {
+	ctx     context.Context
+	stream  *mongo.ChangeStream
+	pending []byte
+	err     error
+}
+
+// WatchChanges opens a change stream on client. It starts after the resume token if there is
+// one, otherwise after from if it's set, otherwise with the next change.
+func WatchChanges(ctx context.Context, client *mongo.Client, resumeToken driverbson.Raw, from bson.MongoTimestamp) (*ChangeStream, error) {
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		// startAfter would also resume after an invalidate, but needs 4.2 and an invalidate
+		// ends the replay anyway
+		opts.SetResumeAfter(resumeToken)
+	} else if from != 0 {
+		// startAtOperationTime includes the time itself, but from has already been replayed
+		opts.SetStartAtOperationTime(&primitive.Timestamp{T: uint32(uint64(from) >> 32), I: uint32(from) + 1})
+	}
+	stream, err := client.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening change stream %s", err)
+	}
+	return &ChangeStream{ctx: ctx, stream: stream}, nil
+}
+
+func (c *ChangeStream) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		// The driver resumes the stream itself after errors like elections
+		if c.stream.Next(c.ctx) {
+			c.pending = append([]byte{}, c.stream.Current...)
+			continue
+		}
+		if err := c.ctx.Err(); err != nil {
+			c.err = err
+		} else if err := c.stream.Err(); err != nil {
+			c.err = fmt.Errorf("Error reading change stream %s", err)
+		} else {
+			log.Printf("The change stream was invalidated, for example by a watched database being dropped")
+			c.err = io.EOF
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Close stops the change stream
+func (c *ChangeStream) Close() error {
+	return c.stream.Close(context.Background())
+}
+
+// resumeTokenPosition reads the resume token of a change event as canonical Extended JSON,
+// since tokens are documents whose contents vary between server versions
+func resumeTokenPosition(event []byte) (string, error) {
+	token, err := driverbson.Raw(event).LookupErr("_id")
+	if err != nil {
+		return "", fmt.Errorf("Change event is missing its resume token %s", err)
+	}
+	doc, ok := token.DocumentOK()
+	if !ok {
+		return "", fmt.Errorf("Change event resume token isn't a document")
+	}
+	out, err := driverbson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// ParseResumeToken reads a resume token saved by a change event Checkpoint
+func ParseResumeToken(value string) (driverbson.Raw, error) {
+	var token driverbson.Raw
+	if err := driverbson.UnmarshalExtJSON([]byte(value), true, &token); err != nil {
+		return nil, fmt.Errorf("Invalid resume token %q %s", value, err)
+	}
+	return token, nil
+}
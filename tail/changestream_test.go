@@ -0,0 +1,69 @@
+package tail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestWatchChangesReplicaSet needs mongod 4.0 or later running on localhost as a replica set,
+// like TestTailReplicaSet
+func TestWatchChangesReplicaSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost").SetServerSelectionTimeout(5*time.Second))
+	if err != nil {
+		t.Skipf("No mongod on localhost: %s", err)
+	}
+	defer client.Disconnect(context.Background())
+	var isMaster struct {
+		SetName string `bson:"setName"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.M{"isMaster": 1}).Decode(&isMaster); err != nil {
+		t.Skipf("No mongod on localhost: %s", err)
+	} else if isMaster.SetName == "" {
+		t.Skip("mongod on localhost isn't a replica set")
+	}
+	collection := client.Database("throttle").Collection("changes")
+	assert.NoError(t, collection.Drop(ctx))
+
+	stream, err := WatchChanges(ctx, client, nil, 0)
+	if err != nil {
+		t.Skip("mongod on localhost isn't a replica set")
+	}
+	defer stream.Close()
+	scanner := bsonScanner.New(stream)
+
+	id := primitive.NewObjectID()
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		collection.InsertOne(ctx, map[string]interface{}{"_id": id, "name": "school"})
+	}()
+
+	assert.True(t, scanner.Scan())
+	var event struct {
+		OperationType string `bson:"operationType"`
+		DocumentKey   bson.M `bson:"documentKey"`
+	}
+	assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &event))
+	assert.Equal(t, "insert", event.OperationType)
+	assert.Equal(t, bson.ObjectIdHex(id.Hex()), event.DocumentKey["_id"])
+
+	position, err := resumeTokenPosition(scanner.Bytes())
+	assert.NoError(t, err)
+	token, err := ParseResumeToken(position)
+	assert.NoError(t, err)
+	_, err = WatchChanges(ctx, client, token, 0)
+	assert.NoError(t, err)
+
+	cancel()
+	assert.False(t, scanner.Scan())
+	assert.Equal(t, context.Canceled, scanner.Err())
+}
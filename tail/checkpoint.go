@@ -0,0 +1,125 @@
+package tail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultCheckpointInterval is how often a Checkpoint is saved by default
+const DefaultCheckpointInterval = 10 * time.Second
+
+// Checkpoint saves the position of the last entry that was replayed to a local file, so
+// tailing can pick up where it left off after a restart. It's safe for concurrent use.
+type Checkpoint struct {
+	path     string
+	interval time.Duration
+	// position reads the position to save from an entry
+	position func(entry []byte) (string, error)
+
+	mu       sync.Mutex
+	value    string
+	saved    string
+	lastSave time.Time
+	now      func() time.Time
+}
+
+// NewCheckpoint returns a Checkpoint of oplog entries, which saves their ts to path at most
+// once per interval
+func NewCheckpoint(path string, interval time.Duration) *Checkpoint {
+	return &Checkpoint{path: path, interval: interval, position: oplogPosition, now: time.Now}
+}
+
+// NewResumeTokenCheckpoint returns a Checkpoint of change events, which saves their resume
+// token to path at most once per interval
+func NewResumeTokenCheckpoint(path string, interval time.Duration) *Checkpoint {
+	return &Checkpoint{path: path, interval: interval, position: resumeTokenPosition, now: time.Now}
+}
+
+func oplogPosition(entry []byte) (string, error) {
+	var doc struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	if err := bson.Unmarshal(entry, &doc); err != nil {
+		return "", err
+	}
+	if doc.Timestamp == 0 {
+		return "", nil
+	}
+	return FormatTimestamp(doc.Timestamp), nil
+}
+
+// Load reads the saved position: a ts that ParseTimestamp reads for oplog entries, or a resume
+// token that ParseResumeToken reads for change events. It's empty if nothing has been saved yet.
+func (c *Checkpoint) Load() (string, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading checkpoint %s", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = strings.TrimSpace(string(data))
+	c.saved = c.value
+	return c.value, nil
+}
+
+// Update records that every entry up to and including entry has been replayed, and saves its
+// position if it's been at least the interval since the last save. It has the signature of
+// apply.Options.Checkpoint.
+func (c *Checkpoint) Update(entry []byte) error {
+	value, err := c.position(entry)
+	if err != nil {
+		return fmt.Errorf("Error reading the position of an entry %s", err)
+	}
+	if value == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = value
+	if c.now().Sub(c.lastSave) < c.interval {
+		return nil
+	}
+	return c.save()
+}
+
+// Flush saves the latest position if it hasn't been saved yet
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+// save writes the position to a temporary file and renames it over the checkpoint, so a crash
+// part way through a write never leaves a corrupt checkpoint behind
+func (c *Checkpoint) save() error {
+	if c.value == c.saved {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		return fmt.Errorf("Error creating checkpoint %s", err)
+	}
+	_, err = fmt.Fprintln(f, c.value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Error saving checkpoint %s", err)
+	}
+	c.saved = c.value
+	c.lastSave = c.now()
+	return nil
+}
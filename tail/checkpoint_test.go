@@ -0,0 +1,82 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func oplogEntry(t *testing.T, ts bson.MongoTimestamp) []byte {
+	raw, err := bson.Marshal(bson.M{"ts": ts, "op": "n"})
+	assert.NoError(t, err)
+	return raw
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+
+	now := time.Unix(0, 0)
+	c := NewCheckpoint(path, time.Minute)
+	c.now = func() time.Time { return now }
+	saved, err := c.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "", saved)
+
+	// The first update is saved, and the next ones wait for the interval
+	now = now.Add(time.Hour)
+	assert.NoError(t, c.Update(oplogEntry(t, 1<<32|1)))
+	assert.NoError(t, c.Update(oplogEntry(t, 1<<32|2)))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1:1\n", string(data))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, c.Update(oplogEntry(t, 2<<32)))
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "2:0\n", string(data))
+
+	assert.NoError(t, c.Update(oplogEntry(t, 3<<32)))
+	assert.NoError(t, c.Flush())
+	saved, err = NewCheckpoint(path, time.Minute).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "3:0", saved)
+
+	// Only the checkpoint is left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestResumeTokenCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+
+	event, err := bson.Marshal(bson.M{"_id": bson.M{"_data": "826F2A"}, "operationType": "insert"})
+	assert.NoError(t, err)
+	c := NewResumeTokenCheckpoint(path, 0)
+	assert.NoError(t, c.Update(event))
+
+	saved, err := NewResumeTokenCheckpoint(path, 0).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_data":"826F2A"}`, saved)
+	token, err := ParseResumeToken(saved)
+	assert.NoError(t, err)
+	assert.Equal(t, "826F2A", token.Lookup("_data").StringValue())
+
+	missing, err := bson.Marshal(bson.M{"operationType": "insert"})
+	assert.NoError(t, err)
+	assert.Error(t, c.Update(missing))
+	_, err = ParseResumeToken("garbage")
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package tail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// tailTimeout is how long a read waits for a new entry before checking whether it's been stopped
+const tailTimeout = time.Second
+
+// maxFailures is how many times in a row the cursor can fail before Reader gives up
+const maxFailures = 5
+
+// Reader tails local.oplog.rs on a replica set member, returning each entry as BSON like an
+// oplog dump, so it can be the input to apply.Run. Reads block until there's a new entry, so
+// it never ends on its own: it returns the context's error once the context is done.
+type Reader struct {
+	ctx     context.Context
+	oplog   *mgo.Collection
+	last    bson.MongoTimestamp
+	iter    *mgo.Iter
+	pending []byte
+	err     error
+
+	failures int
+}
+
+// NewReader returns a Reader of the oplog entries after from. If from is zero it starts with
+// the next entry written.
+func NewReader(ctx context.Context, session *mgo.Session, from bson.MongoTimestamp) *Reader {
+	return &Reader{
+		ctx:   ctx,
+		oplog: session.DB("local").C("oplog.rs"),
+		last:  from,
+	}
+}
+
+// Last is the timestamp of the last entry read
+func (r *Reader) Last() bson.MongoTimestamp {
+	return r.last
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.ctx.Err(); err != nil {
+			r.err = err
+			continue
+		}
+		if r.iter == nil {
+			if err := r.open(); err != nil {
+				r.fail(err)
+				continue
+			}
+		}
+
+		var raw bson.Raw
+		if r.iter.Next(&raw) {
+			var entry struct {
+				Timestamp bson.MongoTimestamp `bson:"ts"`
+			}
+			if err := raw.Unmarshal(&entry); err != nil {
+				r.err = fmt.Errorf("Error reading oplog entry after %s %s", FormatTimestamp(r.last), err)
+				continue
+			}
+			r.last = entry.Timestamp
+			r.pending = raw.Data
+			r.failures = 0
+			continue
+		}
+		if r.iter.Timeout() {
+			// No new entries yet
+			continue
+		}
+		// The cursor died, for example after an election, so reopen it after the last entry read
+		err := r.iter.Close()
+		r.iter = nil
+		if err == nil {
+			err = fmt.Errorf("cursor closed")
+		}
+		r.fail(err)
+	}
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// open starts a tailable cursor after the last entry read, first checking that the entry is
+// still in the oplog. If it isn't, the entries between it and the start of the oplog are gone.
+func (r *Reader) open() error {
+	r.oplog.Database.Session.Refresh()
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	if r.last == 0 {
+		if err := r.oplog.Find(nil).Sort("-$natural").One(&entry); err != nil && err != mgo.ErrNotFound {
+			return fmt.Errorf("Error finding the end of the oplog %s", err)
+		}
+		r.last = entry.Timestamp
+		log.Printf("Tailing the oplog from its end at %s", FormatTimestamp(r.last))
+	} else {
+		if err := r.oplog.Find(nil).Sort("$natural").One(&entry); err != nil && err != mgo.ErrNotFound {
+			return fmt.Errorf("Error finding the start of the oplog %s", err)
+		}
+		if entry.Timestamp > r.last {
+			r.err = fmt.Errorf("The oplog starts at %s, after %s, so entries have been lost. Start again from a new dump",
+				FormatTimestamp(entry.Timestamp), FormatTimestamp(r.last))
+			return r.err
+		}
+		log.Printf("Tailing the oplog after %s", FormatTimestamp(r.last))
+	}
+	r.iter = r.oplog.Find(bson.M{"ts": bson.M{"$gt": r.last}}).LogReplay().Tail(tailTimeout)
+	return nil
+}
+
+// fail records a failure to read the oplog, and gives up after too many in a row
+func (r *Reader) fail(err error) {
+	if r.err != nil {
+		return
+	}
+	r.failures++
+	if r.failures > maxFailures {
+		r.err = fmt.Errorf("Error tailing the oplog after %d attempts %s", maxFailures, err)
+		return
+	}
+	log.Printf("Error tailing the oplog, retrying: %s", err)
+	// Read returns the context's error next if it's done while waiting
+	timer := time.NewTimer(time.Duration(r.failures) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-r.ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// Close stops tailing
+func (r *Reader) Close() error {
+	if r.iter == nil {
+		return nil
+	}
+	err := r.iter.Close()
+	r.iter = nil
+	return err
+}
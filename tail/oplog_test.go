@@ -0,0 +1,76 @@
+package tail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestTailReplicaSet needs mongod running on localhost as a replica set, for example one
+// started with mongod --replSet rs0 and initialized with rs.initiate()
+func TestTailReplicaSet(t *testing.T) {
+	session, err := mgo.DialWithTimeout("localhost", 5*time.Second)
+	if err != nil {
+		t.Skipf("No mongod on localhost: %s", err)
+	}
+	defer session.Close()
+	var isMaster struct {
+		SetName string `bson:"setName"`
+	}
+	if err := session.Run("isMaster", &isMaster); err != nil || isMaster.SetName == "" {
+		t.Skip("mongod on localhost isn't a replica set")
+	}
+	db := session.DB("throttle")
+	assert.NoError(t, db.DropDatabase())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewReader(ctx, session.Copy(), 0)
+	defer r.Close()
+	scanner := bsonScanner.New(r)
+
+	ids := []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId()}
+	go func() {
+		// Give the reader time to find the end of the oplog before writing
+		time.Sleep(500 * time.Millisecond)
+		for _, id := range ids {
+			db.C("tail").Insert(bson.M{"_id": id})
+		}
+	}()
+
+	read := []bson.ObjectId{}
+	for len(read) < len(ids) && scanner.Scan() {
+		var entry struct {
+			Namespace string `bson:"ns"`
+			Object    bson.M `bson:"o"`
+		}
+		assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &entry))
+		if entry.Namespace == "throttle.tail" {
+			read = append(read, entry.Object["_id"].(bson.ObjectId))
+		}
+	}
+	assert.Equal(t, ids, read)
+
+	// Tailing again from the first insert only returns the entries after it
+	var first struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	assert.NoError(t, session.DB("local").C("oplog.rs").Find(bson.M{"o._id": ids[0]}).One(&first))
+	again := bsonScanner.New(NewReader(ctx, session.Copy(), first.Timestamp))
+	assert.True(t, again.Scan())
+	var entry struct {
+		Object bson.M `bson:"o"`
+	}
+	assert.NoError(t, bson.Unmarshal(again.Bytes(), &entry))
+	assert.Equal(t, ids[1], entry.Object["_id"])
+
+	// Stopping the context ends the read with its error
+	cancel()
+	assert.False(t, scanner.Scan())
+	assert.Equal(t, context.Canceled, scanner.Err())
+}
@@ -0,0 +1,83 @@
+package tail
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	// Use custom scanner with higher length limitation
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RecordOptions choose which oplog entries Record writes
+type RecordOptions struct {
+	// Until, if set, stops recording at the first entry after it
+	Until bson.MongoTimestamp
+	// Namespaces, if set, are the only namespaces recorded. They can be patterns like "clever.*".
+	Namespaces []string
+}
+
+// RecordSummary counts what Record did
+type RecordSummary struct {
+	// Entries is the number of oplog entries read
+	Entries int
+	// Recorded is the number of entries written
+	Recorded int
+	// First and Last are the timestamps of the first and last entries written
+	First bson.MongoTimestamp
+	Last  bson.MongoTimestamp
+}
+
+// Record copies oplog entries from r to w, like mongodump of the oplog would, so the file can
+// be replayed later. The no-op entries servers write periodically are left out, since they
+// don't change anything and can't be replayed. It stops when r ends or returns an error,
+// which is returned as it is so callers can tell a context finishing from a failure.
+func Record(r io.Reader, w io.Writer, opts RecordOptions) (RecordSummary, error) {
+	for _, pattern := range opts.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return RecordSummary{}, fmt.Errorf("Invalid namespace pattern %q", pattern)
+		}
+	}
+
+	summary := RecordSummary{}
+	scanner := bsonScanner.NewUnchecked(r)
+	for scanner.Scan() {
+		summary.Entries++
+		var entry struct {
+			Timestamp bson.MongoTimestamp `bson:"ts"`
+			Op        string              `bson:"op"`
+			Namespace string              `bson:"ns"`
+		}
+		if err := bson.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return summary, fmt.Errorf("Error parsing oplog entry at offset %d %s", scanner.Offset(), err)
+		}
+		if opts.Until != 0 && entry.Timestamp > opts.Until {
+			return summary, nil
+		}
+		if entry.Op == "n" || !matchesAny(opts.Namespaces, entry.Namespace) {
+			continue
+		}
+		if _, err := w.Write(scanner.Bytes()); err != nil {
+			return summary, fmt.Errorf("Error writing oplog entry %s", err)
+		}
+		summary.Recorded++
+		if summary.First == 0 {
+			summary.First = entry.Timestamp
+		}
+		summary.Last = entry.Timestamp
+	}
+	return summary, scanner.Err()
+}
+
+func matchesAny(patterns []string, namespace string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
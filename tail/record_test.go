@@ -0,0 +1,89 @@
+package tail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	bsonScanner "github.com/Clever/mongo-op-throttler/bson"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// stoppedReader returns its data, then an error instead of EOF, like a tail whose context
+// has finished
+type stoppedReader struct {
+	io.Reader
+	err error
+}
+
+func (s stoppedReader) Read(b []byte) (int, error) {
+	n, err := s.Reader.Read(b)
+	if err == io.EOF {
+		err = s.err
+	}
+	return n, err
+}
+
+func recordTestOplog(t *testing.T) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	for i, entry := range []bson.M{
+		{"op": "i", "ns": "clever.schools", "o": bson.M{"_id": 1}},
+		{"op": "n", "ns": "", "o": bson.M{"msg": "periodic noop"}},
+		{"op": "i", "ns": "clever.events", "o": bson.M{"_id": 2}},
+		{"op": "u", "ns": "other.schools", "o": bson.M{"$set": bson.M{"a": 1}}, "o2": bson.M{"_id": 1}},
+		{"op": "d", "ns": "clever.schools", "o": bson.M{"_id": 1}},
+	} {
+		entry["ts"] = bson.MongoTimestamp(int64(i+1) << 32)
+		entry["v"] = 2
+		raw, err := bson.Marshal(entry)
+		assert.NoError(t, err)
+		buffer.Write(raw)
+	}
+	return buffer
+}
+
+func recordedNamespaces(t *testing.T, recorded *bytes.Buffer) []string {
+	namespaces := []string{}
+	scanner := bsonScanner.New(recorded)
+	for scanner.Scan() {
+		var entry struct {
+			Namespace string `bson:"ns"`
+		}
+		assert.NoError(t, bson.Unmarshal(scanner.Bytes(), &entry))
+		namespaces = append(namespaces, entry.Namespace)
+	}
+	assert.NoError(t, scanner.Err())
+	return namespaces
+}
+
+func TestRecord(t *testing.T) {
+	recorded := &bytes.Buffer{}
+	summary, err := Record(recordTestOplog(t), recorded, RecordOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, RecordSummary{Entries: 5, Recorded: 4, First: 1 << 32, Last: 5 << 32}, summary)
+	assert.Equal(t, []string{"clever.schools", "clever.events", "other.schools", "clever.schools"}, recordedNamespaces(t, recorded))
+}
+
+func TestRecordFiltered(t *testing.T) {
+	recorded := &bytes.Buffer{}
+	summary, err := Record(recordTestOplog(t), recorded, RecordOptions{Until: 4 << 32, Namespaces: []string{"*.schools"}})
+	assert.NoError(t, err)
+	assert.Equal(t, RecordSummary{Entries: 5, Recorded: 2, First: 1 << 32, Last: 4 << 32}, summary)
+	assert.Equal(t, []string{"clever.schools", "other.schools"}, recordedNamespaces(t, recorded))
+
+	_, err = Record(recordTestOplog(t), recorded, RecordOptions{Namespaces: []string{"clever.[schools"}})
+	assert.Error(t, err)
+}
+
+func TestRecordStopped(t *testing.T) {
+	recorded := &bytes.Buffer{}
+	summary, err := Record(stoppedReader{recordTestOplog(t), context.DeadlineExceeded}, recorded, RecordOptions{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 4, summary.Recorded)
+
+	_, err = Record(stoppedReader{recordTestOplog(t), errors.New("broken")}, &bytes.Buffer{}, RecordOptions{})
+	assert.Equal(t, "broken", err.Error())
+}
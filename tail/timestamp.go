@@ -0,0 +1,35 @@
+package tail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ParseTimestamp parses an oplog timestamp written as seconds since the epoch, as
+// seconds:increment like the mongo shell's Timestamp(seconds, increment), or as an RFC 3339 time
+func ParseTimestamp(value string) (bson.MongoTimestamp, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return bson.MongoTimestamp(t.Unix() << 32), nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	seconds, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid timestamp %q, expected seconds, seconds:increment or an RFC 3339 time", value)
+	}
+	var increment uint64
+	if len(parts) == 2 {
+		if increment, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+			return 0, fmt.Errorf("Invalid timestamp %q, expected seconds, seconds:increment or an RFC 3339 time", value)
+		}
+	}
+	return bson.MongoTimestamp(seconds<<32 | increment), nil
+}
+
+// FormatTimestamp writes an oplog timestamp as seconds:increment, which ParseTimestamp reads
+func FormatTimestamp(ts bson.MongoTimestamp) string {
+	return fmt.Sprintf("%d:%d", uint64(ts)>>32, uint32(ts))
+}
@@ -0,0 +1,27 @@
+package tail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	for value, expected := range map[string]bson.MongoTimestamp{
+		"1500000000":           1500000000 << 32,
+		"1500000000:3":         1500000000<<32 | 3,
+		"2017-07-14T02:40:00Z": 1500000000 << 32,
+	} {
+		ts, err := ParseTimestamp(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ts, value)
+	}
+
+	for _, value := range []string{"", "yesterday", "1500000000:", "-1", "1500000000:3:4"} {
+		_, err := ParseTimestamp(value)
+		assert.Error(t, err, value)
+	}
+
+	assert.Equal(t, "1500000000:3", FormatTimestamp(1500000000<<32|3))
+}
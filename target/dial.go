@@ -0,0 +1,157 @@
+package target
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2"
+)
+
+// dialTimeout is how long to wait to connect to Mongo
+const dialTimeout = 30 * time.Second
+
+// DialOptions describes how to connect and authenticate to Mongo
+type DialOptions struct {
+	// URL is a bare host, for example "localhost", or a mongodb:// URL
+	URL string
+	// TLS connects over TLS. It's implied by any of the TLS files being set.
+	TLS bool
+	// CAFile is a PEM bundle of certificate authorities to trust instead of the system's
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key to present to the server
+	CertFile string
+	KeyFile  string
+	// AuthSource is the database to authenticate against. Defaults to the one in the URL, or admin.
+	AuthSource string
+	// AuthMechanism is the auth mechanism, for example SCRAM-SHA-1 or MONGODB-X509. Defaults to
+	// the server's default.
+	AuthMechanism string
+	// Username and Password are the credentials to authenticate with. They override any in the URL.
+	Username string
+	Password string
+}
+
+// TLSConfig builds the TLS configuration for the connection. It returns nil if TLS isn't used.
+func (o DialOptions) TLSConfig() (*tls.Config, error) {
+	if !o.TLS && o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA file %s", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA file %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("Both a client certificate and key are needed")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// mgoDialInfo converts the options to what mgo.DialWithInfo takes
+func (o DialOptions) mgoDialInfo() (*mgo.DialInfo, error) {
+	info, err := mgo.ParseURL(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = dialTimeout
+	if o.AuthSource != "" {
+		info.Source = o.AuthSource
+	}
+	if o.AuthMechanism != "" {
+		info.Mechanism = o.AuthMechanism
+	}
+	if o.Username != "" {
+		info.Username = o.Username
+	}
+	if o.Password != "" {
+		info.Password = o.Password
+	}
+
+	config, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.Dial("tcp", addr.String(), config)
+		}
+	}
+	return info, nil
+}
+
+// DialMgo connects to Mongo with mgo
+func DialMgo(o DialOptions) (*mgo.Session, error) {
+	info, err := o.mgoDialInfo()
+	if err != nil {
+		return nil, err
+	}
+	return mgo.DialWithInfo(info)
+}
+
+// driverURL adds the scheme the driver needs to bare hosts like mgo.Dial accepts
+func (o DialOptions) driverURL() string {
+	if strings.HasPrefix(o.URL, "mongodb://") || strings.HasPrefix(o.URL, "mongodb+srv://") {
+		return o.URL
+	}
+	return "mongodb://" + o.URL
+}
+
+// driverClientOptions converts the options to the driver's equivalent
+func (o DialOptions) driverClientOptions() (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(o.driverURL())
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	config, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		opts.SetTLSConfig(config)
+	}
+
+	if o.AuthSource != "" || o.AuthMechanism != "" || o.Username != "" || o.Password != "" {
+		// Start from any credentials in the URL so the flags only override what they set
+		var credential options.Credential
+		if opts.Auth != nil {
+			credential = *opts.Auth
+		}
+		if o.AuthSource != "" {
+			credential.AuthSource = o.AuthSource
+		}
+		if o.AuthMechanism != "" {
+			credential.AuthMechanism = o.AuthMechanism
+		}
+		if o.Username != "" {
+			credential.Username = o.Username
+		}
+		if o.Password != "" {
+			credential.Password = o.Password
+			credential.PasswordSet = true
+		}
+		opts.SetAuth(credential)
+	}
+	return opts, nil
+}
@@ -0,0 +1,185 @@
+package target
+
+import (
+	"context"
+	"fmt"
+
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Driver applies operations with the official Mongo Go driver, which supports the auth
+// mechanisms and wire protocol of newer servers that mgo doesn't
+type Driver struct {
+	client *mongo.Client
+	ctx    context.Context
+}
+
+// DialDriver connects to Mongo with the driver
+func DialDriver(dialOpts DialOptions, sessionOpts SessionOptions) (*Driver, error) {
+	clientOpts, err := sessionOpts.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dialClient(dialOpts, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	return NewDriver(client), nil
+}
+
+// DialClient connects a driver client to Mongo, for reading from it rather than applying
+// operations to it
+func DialClient(dialOpts DialOptions) (*mongo.Client, error) {
+	return dialClient(dialOpts)
+}
+
+func dialClient(dialOpts DialOptions, extra ...*options.ClientOptions) (*mongo.Client, error) {
+	connectOpts, err := dialOpts.driverClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, append([]*options.ClientOptions{connectOpts}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewDriver returns a Target that applies operations with an already connected client
+func NewDriver(client *mongo.Client) *Driver {
+	return &Driver{client: client, ctx: context.Background()}
+}
+
+// WithContext implements Contexter. Writes made through the returned Driver are abandoned
+// when ctx is done.
+func (d *Driver) WithContext(ctx context.Context) Target {
+	return &Driver{client: d.client, ctx: ctx}
+}
+
+// Close disconnects the client
+func (d *Driver) Close() error {
+	return d.client.Disconnect(context.Background())
+}
+
+func (d *Driver) collection(namespace string) (*mongo.Collection, error) {
+	db, collection, err := SplitNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Database(db).Collection(collection), nil
+}
+
+// idFilter converts an mgo ObjectId to a filter the driver understands
+func idFilter(id bson.ObjectId) (driverbson.D, error) {
+	oid, err := primitive.ObjectIDFromHex(id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return driverbson.D{{Key: "_id", Value: oid}}, nil
+}
+
+// rawDoc encodes a document with mgo's bson package so that mgo types nested in it, like
+// ObjectIds and timestamps, keep their BSON types when the driver sends it
+func rawDoc(doc bson.M) (driverbson.Raw, error) {
+	if doc == nil {
+		doc = bson.M{}
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling document %s", err)
+	}
+	return driverbson.Raw(raw), nil
+}
+
+// unacknowledged is whether err only means the write concern is w:0, so the driver sent the
+// write without waiting to hear whether it matched anything
+func unacknowledged(err error) bool {
+	return err == mongo.ErrUnacknowledgedWrite
+}
+
+// Upsert implements Target
+func (d *Driver) Upsert(namespace string, id bson.ObjectId, doc bson.M) error {
+	c, err := d.collection(namespace)
+	if err != nil {
+		return err
+	}
+	filter, err := idFilter(id)
+	if err != nil {
+		return err
+	}
+	replacement, err := rawDoc(doc)
+	if err != nil {
+		return err
+	}
+	_, err = c.ReplaceOne(d.ctx, filter, replacement, options.Replace().SetUpsert(true))
+	if unacknowledged(err) {
+		return nil
+	}
+	return err
+}
+
+// Update implements Target
+func (d *Driver) Update(namespace string, id bson.ObjectId, update bson.M) error {
+	c, err := d.collection(namespace)
+	if err != nil {
+		return err
+	}
+	filter, err := idFilter(id)
+	if err != nil {
+		return err
+	}
+	raw, err := rawDoc(update)
+	if err != nil {
+		return err
+	}
+
+	// Unlike mgo's UpdateId, the driver has separate calls for replacements and operators
+	var result *mongo.UpdateResult
+	if hasOperators(update) {
+		result, err = c.UpdateOne(d.ctx, filter, raw)
+	} else {
+		result, err = c.ReplaceOne(d.ctx, filter, raw)
+	}
+	if unacknowledged(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Remove implements Target
+func (d *Driver) Remove(namespace string, id bson.ObjectId) error {
+	c, err := d.collection(namespace)
+	if err != nil {
+		return err
+	}
+	filter, err := idFilter(id)
+	if err != nil {
+		return err
+	}
+	result, err := c.DeleteOne(d.ctx, filter)
+	if unacknowledged(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
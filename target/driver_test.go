@@ -0,0 +1,61 @@
+package target
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestDriver checks that the driver target has the same semantics as the memory one against
+// a live Mongo. It reads the results back with mgo. The driver needs Mongo 3.6 or later.
+func TestDriver(t *testing.T) {
+	session, err := mgo.DialWithTimeout("localhost", 5*time.Second)
+	if err != nil {
+		t.Skipf("No mongod on localhost: %s", err)
+	}
+	defer session.Close()
+	info, err := session.BuildInfo()
+	require.NoError(t, err)
+	if !info.VersionAtLeast(3, 6) {
+		t.Skipf("mongod on localhost is %s, the driver needs 3.6 or later", info.Version)
+	}
+	c := session.DB("throttle").C("driver")
+	c.DropCollection()
+
+	d, err := DialDriver(DialOptions{URL: "localhost"}, SessionOptions{W: "1", J: true})
+	require.NoError(t, err)
+	defer d.Close()
+
+	id := bson.NewObjectId()
+	ref := bson.NewObjectId()
+	assert.NoError(t, d.Upsert("throttle.driver", id, bson.M{"_id": id, "key": "value", "ref": ref}))
+	assert.NoError(t, d.Upsert("throttle.driver", id, bson.M{"_id": id, "key": "value2", "ref": ref, "other": 1}))
+
+	var doc bson.M
+	assert.NoError(t, c.FindId(id).One(&doc))
+	assert.Equal(t, bson.M{"_id": id, "key": "value2", "ref": ref, "other": 1}, doc)
+
+	assert.NoError(t, d.Update("throttle.driver", id, bson.M{"$set": bson.M{"key": "value3"}, "$unset": bson.M{"other": 1}}))
+	assert.NoError(t, c.FindId(id).One(&doc))
+	assert.Equal(t, bson.M{"_id": id, "key": "value3", "ref": ref}, doc)
+
+	assert.NoError(t, d.Update("throttle.driver", id, bson.M{"key": "replaced"}))
+	doc = nil
+	assert.NoError(t, c.FindId(id).One(&doc))
+	assert.Equal(t, bson.M{"_id": id, "key": "replaced"}, doc)
+
+	assert.Equal(t, ErrNotFound, d.Update("throttle.driver", bson.NewObjectId(), bson.M{"$set": bson.M{"key": "value"}}))
+	assert.NoError(t, d.Remove("throttle.driver", id))
+	assert.Equal(t, ErrNotFound, d.Remove("throttle.driver", id))
+
+	// Unacknowledged writes can't tell whether they matched anything, so they all succeed
+	unacknowledged, err := DialDriver(DialOptions{URL: "localhost"}, SessionOptions{W: "0"})
+	require.NoError(t, err)
+	defer unacknowledged.Close()
+	assert.NoError(t, unacknowledged.Upsert("throttle.driver", id, bson.M{"_id": id}))
+	assert.NoError(t, unacknowledged.Remove("throttle.driver", bson.NewObjectId()))
+}
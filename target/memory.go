@@ -0,0 +1,174 @@
+package target
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Memory is an in-memory Target for tests. It follows Mongo's semantics for the operations
+// the throttler applies, including $set and $unset with dotted paths.
+type Memory struct {
+	mu   sync.Mutex
+	docs map[string]map[bson.ObjectId]bson.M
+}
+
+// NewMemory returns an empty Memory target
+func NewMemory() *Memory {
+	return &Memory{docs: map[string]map[bson.ObjectId]bson.M{}}
+}
+
+// Upsert implements Target
+func (m *Memory) Upsert(namespace string, id bson.ObjectId, doc bson.M) error {
+	if _, _, err := SplitNamespace(namespace); err != nil {
+		return err
+	}
+	stored, err := copyDoc(doc)
+	if err != nil {
+		return err
+	}
+	stored["_id"] = id
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.docs[namespace] == nil {
+		m.docs[namespace] = map[bson.ObjectId]bson.M{}
+	}
+	m.docs[namespace][id] = stored
+	return nil
+}
+
+// Update implements Target
+func (m *Memory) Update(namespace string, id bson.ObjectId, update bson.M) error {
+	if _, _, err := SplitNamespace(namespace); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.docs[namespace][id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	updated, err := copyDoc(update)
+	if err != nil {
+		return err
+	}
+	if !hasOperators(updated) {
+		updated["_id"] = id
+		m.docs[namespace][id] = updated
+		return nil
+	}
+
+	// Apply the operators to a copy so a failed update leaves the document untouched
+	doc, err := copyDoc(existing)
+	if err != nil {
+		return err
+	}
+	for operator, arg := range updated {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("Invalid argument to %s: %v", operator, arg)
+		}
+		for path, value := range fields {
+			if path == "_id" {
+				return fmt.Errorf("Cannot %s _id", operator)
+			}
+			switch operator {
+			case "$set":
+				err = setPath(doc, path, value)
+			case "$unset":
+				unsetPath(doc, path)
+			default:
+				return fmt.Errorf("Unsupported update operator: %s", operator)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.docs[namespace][id] = doc
+	return nil
+}
+
+// Remove implements Target
+func (m *Memory) Remove(namespace string, id bson.ObjectId) error {
+	if _, _, err := SplitNamespace(namespace); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.docs[namespace][id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.docs[namespace], id)
+	return nil
+}
+
+// Find returns a copy of the document with the given _id, or nil if there isn't one
+func (m *Memory) Find(namespace string, id bson.ObjectId) bson.M {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.docs[namespace][id]
+	if !ok {
+		return nil
+	}
+	found, _ := copyDoc(doc)
+	return found
+}
+
+// Count returns the number of documents in a namespace
+func (m *Memory) Count(namespace string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.docs[namespace])
+}
+
+// copyDoc deep copies a document by round tripping it through BSON, which also normalizes
+// nested documents to bson.M the same way reading them back from Mongo would
+func copyDoc(doc bson.M) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling document %s", err)
+	}
+	copied := bson.M{}
+	if err := bson.Unmarshal(raw, &copied); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling document %s", err)
+	}
+	return copied, nil
+}
+
+// setPath sets a possibly dotted path in a document, creating embedded documents as needed
+func setPath(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part]
+		if !ok || next == nil {
+			embedded := bson.M{}
+			doc[part] = embedded
+			doc = embedded
+			continue
+		}
+		if doc, ok = next.(bson.M); !ok {
+			return fmt.Errorf("Cannot set %s, %s is not an embedded document", path, part)
+		}
+	}
+	doc[parts[len(parts)-1]] = value
+	return nil
+}
+
+// unsetPath removes a possibly dotted path from a document. Like Mongo, it does nothing if
+// the path doesn't exist.
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, parts[len(parts)-1])
+}
@@ -0,0 +1,75 @@
+package target
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMemoryUpsert(t *testing.T) {
+	m := NewMemory()
+	id := bson.NewObjectId()
+	doc := bson.M{"_id": id, "key": "value", "nested": bson.M{"a": 1}}
+	assert.NoError(t, m.Upsert("throttle.test", id, doc))
+	assert.Equal(t, 1, m.Count("throttle.test"))
+	assert.Equal(t, doc, m.Find("throttle.test", id))
+
+	// The stored document is a copy
+	doc["key"] = "changed"
+	assert.Equal(t, "value", m.Find("throttle.test", id)["key"])
+
+	// Upserting again replaces the whole document
+	assert.NoError(t, m.Upsert("throttle.test", id, bson.M{"other": "value"}))
+	assert.Equal(t, bson.M{"_id": id, "other": "value"}, m.Find("throttle.test", id))
+	assert.Equal(t, 1, m.Count("throttle.test"))
+
+	err := m.Upsert("bad", id, bson.M{})
+	assert.Error(t, err)
+	assert.Equal(t, "Invalid namespace: bad", err.Error())
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	m := NewMemory()
+	id := bson.NewObjectId()
+	assert.NoError(t, m.Upsert("throttle.test", id, bson.M{"key": "value", "nested": bson.M{"a": 1, "b": 2}}))
+
+	// A replacement document keeps the _id
+	assert.NoError(t, m.Update("throttle.test", id, bson.M{"key": "value2", "nested": bson.M{"a": 1, "b": 2}}))
+	assert.Equal(t, bson.M{"_id": id, "key": "value2", "nested": bson.M{"a": 1, "b": 2}}, m.Find("throttle.test", id))
+
+	// $set and $unset work with dotted paths, create embedded documents and ignore missing fields
+	assert.NoError(t, m.Update("throttle.test", id, bson.M{
+		"$set":   bson.M{"key": "value3", "nested.a": 3, "new.field": true},
+		"$unset": bson.M{"nested.b": "", "missing": "", "missing.field": ""},
+	}))
+	assert.Equal(t, bson.M{
+		"_id":    id,
+		"key":    "value3",
+		"nested": bson.M{"a": 3},
+		"new":    bson.M{"field": true},
+	}, m.Find("throttle.test", id))
+
+	// Failed updates leave the document alone
+	err := m.Update("throttle.test", id, bson.M{"$set": bson.M{"other": 1, "key.sub": 1}})
+	assert.Error(t, err)
+	assert.Equal(t, "Cannot set key.sub, key is not an embedded document", err.Error())
+	err = m.Update("throttle.test", id, bson.M{"$inc": bson.M{"count": 1}})
+	assert.Error(t, err)
+	assert.Equal(t, "Unsupported update operator: $inc", err.Error())
+	assert.Equal(t, "value3", m.Find("throttle.test", id)["key"])
+	assert.Nil(t, m.Find("throttle.test", id)["other"])
+
+	assert.Equal(t, ErrNotFound, m.Update("throttle.test", bson.NewObjectId(), bson.M{"key": "value"}))
+	assert.Equal(t, ErrNotFound, m.Update("throttle.other", id, bson.M{"key": "value"}))
+}
+
+func TestMemoryRemove(t *testing.T) {
+	m := NewMemory()
+	id := bson.NewObjectId()
+	assert.NoError(t, m.Upsert("throttle.test", id, bson.M{"key": "value"}))
+	assert.NoError(t, m.Remove("throttle.test", id))
+	assert.Nil(t, m.Find("throttle.test", id))
+	assert.Equal(t, 0, m.Count("throttle.test"))
+	assert.Equal(t, ErrNotFound, m.Remove("throttle.test", id))
+}
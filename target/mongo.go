@@ -0,0 +1,58 @@
+package target
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Mongo applies operations to a Mongo database through an mgo session
+type Mongo struct {
+	session *mgo.Session
+}
+
+// NewMongo returns a Target that applies operations with the given session
+func NewMongo(session *mgo.Session) *Mongo {
+	return &Mongo{session: session}
+}
+
+func (m *Mongo) collection(namespace string) (*mgo.Collection, error) {
+	db, collection, err := SplitNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return m.session.DB(db).C(collection), nil
+}
+
+// Upsert implements Target
+func (m *Mongo) Upsert(namespace string, id bson.ObjectId, doc bson.M) error {
+	c, err := m.collection(namespace)
+	if err != nil {
+		return err
+	}
+	_, err = c.UpsertId(id, doc)
+	return err
+}
+
+// Update implements Target
+func (m *Mongo) Update(namespace string, id bson.ObjectId, update bson.M) error {
+	c, err := m.collection(namespace)
+	if err != nil {
+		return err
+	}
+	return c.UpdateId(id, update)
+}
+
+// Remove implements Target
+func (m *Mongo) Remove(namespace string, id bson.ObjectId) error {
+	c, err := m.collection(namespace)
+	if err != nil {
+		return err
+	}
+	return c.RemoveId(id)
+}
+
+// Refresh makes mgo drop sockets that may be broken and find the current primary again.
+// It's called between retries.
+func (m *Mongo) Refresh() {
+	m.session.Refresh()
+}
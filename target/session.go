@@ -0,0 +1,128 @@
+package target
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"gopkg.in/mgo.v2"
+)
+
+// SessionOptions controls how safely operations are written and which members reads go to
+type SessionOptions struct {
+	// W is the write concern's w, either a number of members or a tag like "majority".
+	// Empty uses the server's default.
+	W string
+	// J waits for writes to be written to the journal
+	J bool
+	// WTimeout is how long to wait for the write concern before failing. Zero waits forever.
+	WTimeout time.Duration
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred or
+	// nearest. Empty uses the client's default, which is primary unless the URL says otherwise.
+	ReadPreference string
+}
+
+var mgoModes = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primarypreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondarypreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// Validate checks that the options are ones both mgo and the driver understand
+func (o SessionOptions) Validate() error {
+	if n, err := strconv.Atoi(o.W); err == nil && n < 0 {
+		return fmt.Errorf("Invalid write concern w: %s", o.W)
+	}
+	if o.WTimeout < 0 {
+		return fmt.Errorf("Invalid write concern wtimeout: %s", o.WTimeout)
+	}
+	if _, ok := mgoModes[strings.ToLower(o.ReadPreference)]; o.ReadPreference != "" && !ok {
+		return fmt.Errorf("Unknown read preference: %s", o.ReadPreference)
+	}
+	return nil
+}
+
+// hasWriteConcern is whether any part of the write concern is set
+func (o SessionOptions) hasWriteConcern() bool {
+	return o.W != "" || o.J || o.WTimeout > 0
+}
+
+// String describes the options for logs, for example "w=majority j=true wtimeout=5s readPreference=primary"
+func (o SessionOptions) String() string {
+	w, readPreference := o.W, o.ReadPreference
+	if w == "" {
+		w = "default"
+	}
+	if readPreference == "" {
+		readPreference = "default"
+	}
+	return fmt.Sprintf("w=%s j=%t wtimeout=%s readPreference=%s", w, o.J, o.WTimeout, readPreference)
+}
+
+// mgoSafe converts the write concern to mgo's equivalent. A nil result means writes aren't acknowledged.
+func (o SessionOptions) mgoSafe() *mgo.Safe {
+	if o.W == "0" && !o.J {
+		return nil
+	}
+	safe := &mgo.Safe{J: o.J, WTimeout: int(o.WTimeout / time.Millisecond)}
+	if n, err := strconv.Atoi(o.W); err == nil {
+		safe.W = n
+	} else {
+		safe.WMode = o.W
+	}
+	return safe
+}
+
+// Apply sets the write concern and read preference on an mgo session. Options that aren't
+// set leave the session's alone.
+func (o SessionOptions) Apply(session *mgo.Session) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.ReadPreference != "" {
+		session.SetMode(mgoModes[strings.ToLower(o.ReadPreference)], true)
+	}
+	if o.hasWriteConcern() {
+		session.SetSafe(o.mgoSafe())
+	}
+	return nil
+}
+
+// clientOptions converts the options to the driver's equivalent
+func (o SessionOptions) clientOptions() (*options.ClientOptions, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	opts := options.Client()
+	if o.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(o.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		readPref, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(readPref)
+	}
+
+	if o.hasWriteConcern() {
+		wc := &writeconcern.WriteConcern{WTimeout: o.WTimeout}
+		if n, err := strconv.Atoi(o.W); err == nil {
+			wc.W = n
+		} else if o.W != "" {
+			wc.W = o.W
+		}
+		if o.J {
+			wc.Journal = &o.J
+		}
+		opts.SetWriteConcern(wc)
+	}
+	return opts, nil
+}
@@ -0,0 +1,53 @@
+package target
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"gopkg.in/mgo.v2"
+)
+
+func TestSessionOptionsValidate(t *testing.T) {
+	assert.NoError(t, SessionOptions{}.Validate())
+	assert.NoError(t, SessionOptions{W: "majority", J: true, WTimeout: time.Second, ReadPreference: "secondaryPreferred"}.Validate())
+
+	err := SessionOptions{W: "-1"}.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "Invalid write concern w: -1", err.Error())
+
+	err = SessionOptions{ReadPreference: "fastest"}.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "Unknown read preference: fastest", err.Error())
+}
+
+func TestSessionOptionsString(t *testing.T) {
+	assert.Equal(t, "w=default j=false wtimeout=0s readPreference=default", SessionOptions{}.String())
+	assert.Equal(t, "w=majority j=true wtimeout=5s readPreference=nearest",
+		SessionOptions{W: "majority", J: true, WTimeout: 5 * time.Second, ReadPreference: "nearest"}.String())
+}
+
+func TestMgoSafe(t *testing.T) {
+	assert.Equal(t, &mgo.Safe{W: 2, WTimeout: 1500}, SessionOptions{W: "2", WTimeout: 1500 * time.Millisecond}.mgoSafe())
+	assert.Equal(t, &mgo.Safe{WMode: "majority", J: true}, SessionOptions{W: "majority", J: true}.mgoSafe())
+	assert.Nil(t, SessionOptions{W: "0"}.mgoSafe())
+}
+
+func TestSessionClientOptions(t *testing.T) {
+	opts, err := SessionOptions{}.clientOptions()
+	assert.NoError(t, err)
+	assert.Nil(t, opts.WriteConcern)
+	assert.Nil(t, opts.ReadPreference)
+
+	opts, err = SessionOptions{W: "majority", J: true, WTimeout: time.Second, ReadPreference: "secondaryPreferred"}.clientOptions()
+	assert.NoError(t, err)
+	journal := true
+	assert.Equal(t, &writeconcern.WriteConcern{W: "majority", Journal: &journal, WTimeout: time.Second}, opts.WriteConcern)
+	assert.Equal(t, readpref.SecondaryPreferredMode, opts.ReadPreference.Mode())
+
+	opts, err = SessionOptions{W: "1"}.clientOptions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, opts.WriteConcern.W)
+}
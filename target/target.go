@@ -0,0 +1,51 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotFound is returned by Update and Remove when there's no document with the given _id
+var ErrNotFound = mgo.ErrNotFound
+
+// Target is somewhere operations can be applied. Namespaces are "database.collection".
+type Target interface {
+	// Upsert replaces the document with the given _id, inserting it if it doesn't exist
+	Upsert(namespace string, id bson.ObjectId, doc bson.M) error
+	// Update applies an update to the document with the given _id. The update is either a
+	// replacement document or uses update operators like $set and $unset.
+	Update(namespace string, id bson.ObjectId, update bson.M) error
+	// Remove deletes the document with the given _id
+	Remove(namespace string, id bson.ObjectId) error
+}
+
+// Contexter is implemented by Targets whose writes can be abandoned part way through, for
+// example when the replay is stopped
+type Contexter interface {
+	// WithContext returns a Target whose writes are abandoned when ctx is done
+	WithContext(ctx context.Context) Target
+}
+
+// SplitNamespace splits a namespace into its database and collection
+func SplitNamespace(namespace string) (string, string, error) {
+	split := strings.SplitN(namespace, ".", 2)
+	if len(split) != 2 {
+		return "", "", fmt.Errorf("Invalid namespace: %s", namespace)
+	}
+	return split[0], split[1], nil
+}
+
+// hasOperators is whether an update uses update operators like $set, rather than being a
+// replacement document
+func hasOperators(update bson.M) bool {
+	for key := range update {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
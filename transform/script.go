@@ -0,0 +1,315 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/robertkrimen/otto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultScriptTimeout is how long a single call to the script's transform function can run
+// before it's interrupted
+const DefaultScriptTimeout = time.Second
+
+var errScriptTimeout = errors.New("Script timed out")
+
+// Script is a Transformer backed by a JavaScript function. The script must define a global
+// function named `transform` that takes an op of the form:
+//
+//	{id: "...", type: "insert", namespace: "db.collection", obj: {...}}
+//
+// and returns either null / undefined to drop the op, a single op, or an array of ops to fan
+// it out. For example:
+//
+//	function transform(op) {
+//	  if (op.namespace === "clever.events") { return null; }
+//	  op.namespace = op.namespace.replace("clever.", "clever_copy.");
+//	  return op;
+//	}
+//
+// The script runs in an in-process interpreter with no access to the filesystem or the
+// network. Values in obj that don't have a JavaScript equivalent (ObjectIds, dates, ...) are
+// passed through to the script untouched and written back as is. JavaScript only has floats, so
+// whole numbers the script sets on fields that were integers are converted back to the field's
+// integer type. The script works on a copy of the op, so the original isn't modified.
+//
+// A Script isn't safe for concurrent use.
+type Script struct {
+	vm      *otto.Otto
+	fn      otto.Value
+	timeout time.Duration
+	// call counts calls to Transform, so that a timeout that fires as one call finishes can't
+	// interrupt the next
+	call int
+}
+
+// NewScript compiles the source and checks that it defines a transform function
+func NewScript(src string) (*Script, error) {
+	vm := otto.New()
+	if _, err := vm.Run(src); err != nil {
+		return nil, fmt.Errorf("Error loading transform script: %s", err)
+	}
+	fn, err := vm.Get("transform")
+	if err != nil {
+		return nil, fmt.Errorf("Error loading transform script: %s", err)
+	}
+	if !fn.IsFunction() {
+		return nil, fmt.Errorf("Transform script must define a 'transform' function")
+	}
+	vm.Interrupt = make(chan func(), 1)
+	return &Script{vm: vm, fn: fn, timeout: DefaultScriptTimeout}, nil
+}
+
+// SetTimeout changes how long a single call to transform can run. Zero disables the timeout.
+func (s *Script) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// Transform runs the script's transform function on the op
+func (s *Script) Transform(op operation.Op) (ops []operation.Op, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errScriptTimeout {
+				panic(r)
+			}
+			err = fmt.Errorf("Transform script took longer than %s on op %s", s.timeout, op.ID)
+		}
+	}()
+
+	s.call++
+	if s.timeout > 0 {
+		// The interrupt runs on this goroutine, inside the vm, so it can check s.call directly
+		call := s.call
+		interrupt := func() {
+			if s.call == call {
+				panic(errScriptTimeout)
+			}
+		}
+		done := make(chan struct{})
+		timer := time.AfterFunc(s.timeout, func() {
+			select {
+			case s.vm.Interrupt <- interrupt:
+			case <-done:
+			}
+		})
+		defer func() {
+			timer.Stop()
+			close(done)
+		}()
+	}
+
+	arg, err := s.vm.ToValue(opToScript(op))
+	if err != nil {
+		return nil, fmt.Errorf("Error passing op to transform script: %s", err)
+	}
+	result, err := s.fn.Call(otto.NullValue(), arg)
+	if err != nil {
+		return nil, fmt.Errorf("Error running transform script: %s", err)
+	}
+	exported, err := result.Export()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading transform script result: %s", err)
+	}
+	if ops, err = opsFromScript(exported); err != nil {
+		return nil, err
+	}
+	// The script doesn't see the timestamp, so every op it returns keeps the original's
+	for i := range ops {
+		ops[i].Timestamp = op.Timestamp
+		if ops[i].Obj != nil {
+			ops[i].Obj = restoreInts(ops[i].Obj, op.Obj).(bson.M)
+		}
+	}
+	return ops, nil
+}
+
+// opToScript converts an op to the value passed to the script. obj is copied, since otto wraps
+// Go maps rather than copying them and the script would otherwise modify the caller's op.
+func opToScript(op operation.Op) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        op.ID,
+		"type":      op.Type,
+		"namespace": op.Namespace,
+		"obj":       copyValue(op.Obj),
+	}
+}
+
+// copyValue deep copies the maps and slices in a document
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		if t == nil {
+			return t
+		}
+		c := bson.M{}
+		for key, value := range t {
+			c[key] = copyValue(value)
+		}
+		return c
+	case map[string]interface{}:
+		if t == nil {
+			return t
+		}
+		c := map[string]interface{}{}
+		for key, value := range t {
+			c[key] = copyValue(value)
+		}
+		return c
+	case []interface{}:
+		if t == nil {
+			return t
+		}
+		c := make([]interface{}, len(t))
+		for i, value := range t {
+			c[i] = copyValue(value)
+		}
+		return c
+	case bson.D:
+		if t == nil {
+			return t
+		}
+		c := make(bson.D, len(t))
+		for i, elem := range t {
+			c[i] = bson.DocElem{Name: elem.Name, Value: copyValue(elem.Value)}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// restoreInts converts the numbers the script set back to the integer type the same field had in
+// the original document. JavaScript only has floats, so without this an int field the script
+// touched would be written back as a double or with a different size.
+func restoreInts(v, original interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		if t != math.Trunc(t) || math.Abs(t) >= 1<<63 {
+			return t
+		}
+		return toIntType(int64(t), original, v)
+	case int64:
+		return toIntType(t, original, v)
+	case bson.M:
+		for key, value := range t {
+			t[key] = restoreInts(value, field(original, key))
+		}
+		return t
+	case map[string]interface{}:
+		for key, value := range t {
+			t[key] = restoreInts(value, field(original, key))
+		}
+		return t
+	case []interface{}:
+		originals, _ := original.([]interface{})
+		for i, value := range t {
+			var o interface{}
+			if i < len(originals) {
+				o = originals[i]
+			}
+			t[i] = restoreInts(value, o)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// toIntType converts n to the integer type of original, or returns v if original isn't an integer
+func toIntType(n int64, original, v interface{}) interface{} {
+	switch original.(type) {
+	case int:
+		return int(n)
+	case int32:
+		return int32(n)
+	case int64:
+		return n
+	}
+	return v
+}
+
+// field returns a field of a document, or nil if it isn't a document or doesn't have the field
+func field(doc interface{}, key string) interface{} {
+	switch t := doc.(type) {
+	case bson.M:
+		return t[key]
+	case map[string]interface{}:
+		return t[key]
+	case bson.D:
+		for _, elem := range t {
+			if elem.Name == key {
+				return elem.Value
+			}
+		}
+	}
+	return nil
+}
+
+// opsFromScript converts the value returned by the script back into ops. otto exports arrays of
+// objects as []map[string]interface{} and mixed arrays as []interface{}, so we handle both.
+func opsFromScript(result interface{}) ([]operation.Op, error) {
+	switch t := result.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		op, err := opFromScript(t)
+		if err != nil {
+			return nil, err
+		}
+		return []operation.Op{op}, nil
+	case []map[string]interface{}:
+		ops := []operation.Op{}
+		for _, m := range t {
+			op, err := opFromScript(m)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+		return ops, nil
+	case []interface{}:
+		ops := []operation.Op{}
+		for _, v := range t {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Transform script returned a non-object op %#v", v)
+			}
+			op, err := opFromScript(m)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+		return ops, nil
+	default:
+		return nil, fmt.Errorf("Transform script returned an invalid value %#v", result)
+	}
+}
+
+func opFromScript(m map[string]interface{}) (operation.Op, error) {
+	op := operation.Op{}
+	var ok bool
+	if op.ID, ok = m["id"].(string); !ok {
+		return op, fmt.Errorf("Transform script returned an op without a string id %#v", m)
+	}
+	if op.Type, ok = m["type"].(string); !ok {
+		return op, fmt.Errorf("Transform script returned an op without a string type %#v", m)
+	}
+	if op.Namespace, ok = m["namespace"].(string); !ok {
+		return op, fmt.Errorf("Transform script returned an op without a string namespace %#v", m)
+	}
+	switch obj := m["obj"].(type) {
+	case nil:
+	case bson.M:
+		op.Obj = obj
+	case map[string]interface{}:
+		op.Obj = bson.M(obj)
+	default:
+		return op, fmt.Errorf("Transform script returned an op with an invalid obj %#v", m)
+	}
+	return op, nil
+}
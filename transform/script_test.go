@@ -0,0 +1,133 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func testOp() operation.Op {
+	id := bson.NewObjectId()
+	return operation.Op{
+		ID:        id.Hex(),
+		Type:      "insert",
+		Namespace: "throttle.test",
+		Obj:       bson.M{"_id": id, "key": "value"},
+	}
+}
+
+func TestScriptModifiesOp(t *testing.T) {
+	script, err := NewScript(`
+		function transform(op) {
+			op.namespace = "throttle.renamed";
+			op.obj.key = op.obj.key + "2";
+			return op;
+		}`)
+	assert.NoError(t, err)
+
+	op := testOp()
+	ops, err := script.Transform(op)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ops))
+	assert.Equal(t, "throttle.renamed", ops[0].Namespace)
+	assert.Equal(t, "value2", ops[0].Obj["key"])
+	// Values without a JavaScript equivalent are passed through untouched
+	assert.Equal(t, op.Obj["_id"], ops[0].Obj["_id"])
+}
+
+func TestScriptDoesNotModifyOriginal(t *testing.T) {
+	script, err := NewScript(`
+		function transform(op) {
+			op.obj.key = "changed";
+			op.obj.nested.key = "changed";
+			return op;
+		}`)
+	assert.NoError(t, err)
+
+	op := testOp()
+	op.Obj["nested"] = bson.M{"key": "value"}
+	ops, err := script.Transform(op)
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", ops[0].Obj["key"])
+	assert.Equal(t, "value", op.Obj["key"])
+	assert.Equal(t, bson.M{"key": "value"}, op.Obj["nested"])
+}
+
+func TestScriptKeepsIntTypes(t *testing.T) {
+	script, err := NewScript(`
+		function transform(op) {
+			op.obj.count = op.obj.count + 1;
+			op.obj.big = op.obj.big * 2;
+			op.obj.ratio = op.obj.ratio / 2;
+			op.obj.nested.count = 5;
+			return op;
+		}`)
+	assert.NoError(t, err)
+
+	op := testOp()
+	op.Obj["count"] = 1
+	op.Obj["big"] = int64(1) << 40
+	op.Obj["ratio"] = 3
+	op.Obj["nested"] = bson.M{"count": int32(2)}
+	ops, err := script.Transform(op)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ops[0].Obj["count"])
+	assert.Equal(t, int64(1)<<41, ops[0].Obj["big"])
+	// Fractions stay floats
+	assert.Equal(t, 1.5, ops[0].Obj["ratio"])
+	assert.Equal(t, int32(5), ops[0].Obj["nested"].(bson.M)["count"])
+}
+
+func TestScriptDropsOp(t *testing.T) {
+	script, err := NewScript(`function transform(op) { return null; }`)
+	assert.NoError(t, err)
+
+	ops, err := script.Transform(testOp())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(ops))
+}
+
+func TestScriptFansOutOp(t *testing.T) {
+	script, err := NewScript(`
+		function transform(op) {
+			var copy = {id: op.id, type: op.type, namespace: "throttle.copy", obj: {key: "copy"}};
+			return [op, copy];
+		}`)
+	assert.NoError(t, err)
+
+	ops, err := script.Transform(testOp())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ops))
+	assert.Equal(t, "throttle.test", ops[0].Namespace)
+	assert.Equal(t, "throttle.copy", ops[1].Namespace)
+	assert.Equal(t, "copy", ops[1].Obj["key"])
+}
+
+func TestScriptMissingTransform(t *testing.T) {
+	_, err := NewScript(`var x = 1;`)
+	assert.Error(t, err)
+	assert.Equal(t, "Transform script must define a 'transform' function", err.Error())
+}
+
+func TestScriptInvalidResult(t *testing.T) {
+	script, err := NewScript(`function transform(op) { return {id: op.id}; }`)
+	assert.NoError(t, err)
+
+	_, err = script.Transform(testOp())
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "without a string type"))
+}
+
+func TestScriptTimeout(t *testing.T) {
+	script, err := NewScript(`function transform(op) { while (true) {} }`)
+	assert.NoError(t, err)
+	script.SetTimeout(50 * time.Millisecond)
+
+	_, err = script.Transform(testOp())
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "took longer than"))
+}
@@ -0,0 +1,57 @@
+package transform
+
+import (
+	"strings"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+)
+
+// Transformer rewrites an operation before it is applied. It can return the operation
+// modified in place, no operations at all to drop it, or several operations to fan it out.
+type Transformer interface {
+	Transform(op operation.Op) ([]operation.Op, error)
+}
+
+// Func adapts an ordinary function to the Transformer interface
+type Func func(op operation.Op) ([]operation.Op, error)
+
+// Transform calls f(op)
+func (f Func) Transform(op operation.Op) ([]operation.Op, error) {
+	return f(op)
+}
+
+// Chain runs transformers one after another. Each operation a transformer returns is passed
+// to the next one.
+func Chain(transformers ...Transformer) Transformer {
+	return Func(func(op operation.Op) ([]operation.Op, error) {
+		ops := []operation.Op{op}
+		for _, t := range transformers {
+			var next []operation.Op
+			for _, op := range ops {
+				transformed, err := t.Transform(op)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, transformed...)
+			}
+			ops = next
+		}
+		return ops, nil
+	})
+}
+
+// Remap renames namespaces. Keys can be a whole namespace like "clever.events", or just a
+// database like "clever" to move all of its collections. A namespace match takes precedence
+// over a database match.
+func Remap(namespaces map[string]string) Transformer {
+	return Func(func(op operation.Op) ([]operation.Op, error) {
+		if to, ok := namespaces[op.Namespace]; ok {
+			op.Namespace = to
+		} else if split := strings.SplitN(op.Namespace, ".", 2); len(split) == 2 {
+			if to, ok := namespaces[split[0]]; ok {
+				op.Namespace = to + "." + split[1]
+			}
+		}
+		return []operation.Op{op}, nil
+	})
+}
@@ -0,0 +1,57 @@
+package transform
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Clever/mongo-op-throttler/operation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemap(t *testing.T) {
+	remap := Remap(map[string]string{
+		"clever":        "clever_copy",
+		"clever.events": "archive.events",
+	})
+
+	ops, err := remap.Transform(operation.Op{Namespace: "clever.sections"})
+	assert.NoError(t, err)
+	assert.Equal(t, []operation.Op{{Namespace: "clever_copy.sections"}}, ops)
+
+	ops, err = remap.Transform(operation.Op{Namespace: "clever.events"})
+	assert.NoError(t, err)
+	assert.Equal(t, []operation.Op{{Namespace: "archive.events"}}, ops)
+
+	ops, err = remap.Transform(operation.Op{Namespace: "other.sections"})
+	assert.NoError(t, err)
+	assert.Equal(t, []operation.Op{{Namespace: "other.sections"}}, ops)
+}
+
+func TestChain(t *testing.T) {
+	fanOut := Func(func(op operation.Op) ([]operation.Op, error) {
+		dup := op
+		dup.ID = "dup"
+		return []operation.Op{op, dup}, nil
+	})
+	dropDups := Func(func(op operation.Op) ([]operation.Op, error) {
+		if op.ID == "dup" {
+			return nil, nil
+		}
+		op.Type = "update"
+		return []operation.Op{op}, nil
+	})
+
+	ops, err := Chain(fanOut, dropDups).Transform(operation.Op{ID: "id", Type: "insert"})
+	assert.NoError(t, err)
+	assert.Equal(t, []operation.Op{{ID: "id", Type: "update"}}, ops)
+
+	ops, err = Chain().Transform(operation.Op{ID: "id"})
+	assert.NoError(t, err)
+	assert.Equal(t, []operation.Op{{ID: "id"}}, ops)
+
+	failing := Func(func(op operation.Op) ([]operation.Op, error) {
+		return nil, errors.New("failed")
+	})
+	_, err = Chain(fanOut, failing).Transform(operation.Op{ID: "id"})
+	assert.Error(t, err)
+}